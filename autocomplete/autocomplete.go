@@ -5,6 +5,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+
+	"github.com/kusubooru/tagaa/bulk"
 )
 
 const (
@@ -15,32 +17,20 @@ const (
 	minAllowedQueryLength   = 3
 )
 
-type Category int
+// Category is the name of the category of a tag. Tags of the categories
+// defined in bulk.TagCategories use the name of their category.
+type Category string
 
 const (
-	Unknown Category = iota
-	Normal
-	Artist
-	Character
-	Series
-	Tk
+	Unknown Category = "unknown"
+	Normal  Category = "normal"
 )
 
 func (c Category) String() string {
-	switch c {
-	case Normal:
-		return "normal"
-	case Artist:
-		return "artist"
-	case Character:
-		return "character"
-	case Series:
-		return "series"
-	case Tk:
-		return "tk"
-	default:
-		return "unknown"
+	if c == "" {
+		return string(Unknown)
 	}
+	return string(c)
 }
 
 func (c *Category) UnmarshalJSON(b []byte) error {
@@ -48,18 +38,8 @@ func (c *Category) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &s); err != nil {
 		return err
 	}
-	switch strings.ToLower(s) {
-	case "normal":
-		*c = Normal
-	case "artist":
-		*c = Artist
-	case "character":
-		*c = Character
-	case "series":
-		*c = Series
-	case "tk":
-		*c = Tk
-	default:
+	*c = Category(strings.ToLower(s))
+	if *c == "" {
 		*c = Unknown
 	}
 	return nil
@@ -69,6 +49,14 @@ func (c Category) MarshalJSON() ([]byte, error) {
 	return json.Marshal(c.String())
 }
 
+// categoryOf returns the category of a tag by its prefix.
+func categoryOf(name string) Category {
+	if c, ok := bulk.TagCategories.Of(name); ok {
+		return Category(c.Name)
+	}
+	return Normal
+}
+
 type danbooruCategory int
 
 const (
@@ -76,21 +64,26 @@ const (
 	artist                     = 1
 	series                     = 3
 	character                  = 4
+	meta                       = 5
 )
 
+// danbooruPrefixes are the prefixes the tags of the Danbooru categories have
+// on Kusubooru.
+var danbooruPrefixes = map[danbooruCategory]string{
+	artist:    "artist:",
+	series:    "series:",
+	character: "character:",
+	meta:      "meta:",
+}
+
+// Category returns the configured category of the prefix of c, or Normal if
+// no category of bulk.TagCategories has that prefix.
 func (c danbooruCategory) Category() Category {
-	switch c {
-	case normal:
-		return Normal
-	case artist:
-		return Artist
-	case series:
-		return Series
-	case character:
-		return Character
-	default:
+	prefix, ok := danbooruPrefixes[c]
+	if !ok {
 		return Normal
 	}
+	return categoryOf(prefix)
 }
 
 type Tag struct {
@@ -103,7 +96,29 @@ type Tag struct {
 
 type autocompleteFn func(query string) ([]*Tag, error)
 
+// sources are the boards suggestions can be taken from.
+var sources = map[string]autocompleteFn{
+	teianBoard:    getTeianAutocomplete,
+	danbooruBoard: getDanbooruAutocomplete,
+}
+
+// Boards returns the names of the boards suggestions can be taken from.
+func Boards() []string {
+	return []string{teianBoard, danbooruBoard}
+}
+
+// Sources are the names of the boards GetTags takes suggestions from. Unknown
+// names are ignored.
+var Sources = Boards()
+
+// GetTags returns the suggestions for q of the boards named by Sources.
 func GetTags(q string) ([]*Tag, error) {
+	return GetTagsFrom(q, Sources)
+}
+
+// GetTagsFrom returns the suggestions for q of the named boards. Unknown names
+// are ignored.
+func GetTagsFrom(q string, boards []string) ([]*Tag, error) {
 	q = strings.TrimSpace(q)
 	if len(q) < minAllowedQueryLength {
 		return []*Tag{}, nil
@@ -113,7 +128,12 @@ func GetTags(q string) ([]*Tag, error) {
 	defer close(errch)
 	tagsch := make(chan []*Tag)
 	defer close(tagsch)
-	autocompletes := []autocompleteFn{getTeianAutocomplete, getDanbooruAutocomplete}
+	var autocompletes []autocompleteFn
+	for _, name := range boards {
+		if fn, ok := sources[name]; ok {
+			autocompletes = append(autocompletes, fn)
+		}
+	}
 	for _, aufn := range autocompletes {
 		go func(fn autocompleteFn, query string) {
 			tags, err := fn(query)
@@ -162,18 +182,7 @@ func getTeianAutocomplete(q string) ([]*Tag, error) {
 	}
 	for _, t := range tags {
 		t.Board = teianBoard
-		switch {
-		case strings.HasPrefix(t.Name, "artist:"):
-			t.Category = Artist
-		case strings.HasPrefix(t.Name, "character:"):
-			t.Category = Character
-		case strings.HasPrefix(t.Name, "series:"):
-			t.Category = Series
-		case strings.HasPrefix(t.Name, "tk:"):
-			t.Category = Tk
-		default:
-			t.Category = Normal
-		}
+		t.Category = categoryOf(t.Name)
 	}
 	return tags, nil
 }
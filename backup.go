@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/safepath"
+)
+
+// backupTimeFormat is the format of the time added to the names of backups.
+// It sorts in chronological order.
+const backupTimeFormat = "20060102-150405.000"
+
+// backup is a previous version of the CSV file kept in the backups folder.
+type backup struct {
+	Name string
+	Time time.Time
+	// Diff holds what changed from the backup to the current CSV file, or
+	// Err why they could not be compared.
+	Diff bulk.Diff
+	Err  error
+}
+
+// writeFileAtomic writes data to a temporary file next to filename, flushes it
+// to disk and then renames it to filename. Readers see either the old or the
+// new content and a failed write leaves the old content in place.
+func writeFileAtomic(filename string, data []byte) (err error) {
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	// Temporary files are only readable by their owner so the mode of the
+	// replaced file is kept.
+	mode := os.FileMode(0644)
+	if info, serr := os.Stat(filename); serr == nil {
+		mode = info.Mode()
+	}
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// writeCSVFile replaces the content of the CSV file with data, keeping its
+// previous content in the backups folder.
+func writeCSVFile(dir, csvFilename string, data []byte) error {
+	filename, err := safepath.CSV(dir, csvFilename)
+	if err != nil {
+		return err
+	}
+	if err := backupCSVFile(dir, csvFilename, data); err != nil {
+		return fmt.Errorf("could not back up CSV file: %v", err)
+	}
+	return writeFileAtomic(filename, data)
+}
+
+// backupCSVFile copies the current content of the CSV file to the backups
+// folder unless it is the same as data, and removes the oldest backups past
+// the configured number.
+func backupCSVFile(dir, csvFilename string, data []byte) error {
+	if projectConfig().Backups <= 0 {
+		return nil
+	}
+	current, err := ioutil.ReadFile(filepath.Join(dir, csvFilename))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, data) || len(current) == 0 {
+		return nil
+	}
+	if err := os.MkdirAll(projectPath(dir, backupsDir), 0755); err != nil {
+		return err
+	}
+	ext := filepath.Ext(csvFilename)
+	name := strings.TrimSuffix(filepath.Base(csvFilename), ext) + "-" + time.Now().Format(backupTimeFormat) + ext
+	if err := writeFileAtomic(projectPath(dir, filepath.Join(backupsDir, name)), current); err != nil {
+		return err
+	}
+
+	backups, err := findBackups(dir, csvFilename)
+	if err != nil {
+		return err
+	}
+	for i := projectConfig().Backups; i < len(backups); i++ {
+		if err := os.Remove(projectPath(dir, filepath.Join(backupsDir, backups[i].Name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findBackups returns the backups of the CSV file, newest first.
+func findBackups(dir, csvFilename string) ([]backup, error) {
+	files, err := ioutil.ReadDir(projectPath(dir, backupsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(csvFilename)
+	prefix := strings.TrimSuffix(filepath.Base(csvFilename), ext) + "-"
+	var backups []backup
+	for _, f := range files {
+		name := f.Name()
+		if f.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext), time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{Name: name, Time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Time.After(backups[j].Time) })
+	return backups, nil
+}
+
+// readBackup returns the content of the backup with the given name. Only the
+// names returned by findBackups are accepted.
+func readBackup(dir, csvFilename, name string) ([]byte, error) {
+	backups, err := findBackups(dir, csvFilename)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range backups {
+		if b.Name == name {
+			return ioutil.ReadFile(projectPath(dir, filepath.Join(backupsDir, name)))
+		}
+	}
+	return nil, fmt.Errorf("no backup named %q", name)
+}
+
+// diffBackups compares each backup to the current CSV file.
+func diffBackups(m *model, backups []backup) error {
+	current, err := ioutil.ReadFile(filepath.Join(m.WorkingDir, m.CSVFilename))
+	if err != nil {
+		return err
+	}
+	currentImages, err := loadCSV(bytes.NewReader(current), m.WorkingDir, m.lenientCSV())
+	if err != nil {
+		return err
+	}
+	for i := range backups {
+		data, err := readBackup(m.WorkingDir, m.CSVFilename, backups[i].Name)
+		if err != nil {
+			backups[i].Err = err
+			continue
+		}
+		images, err := loadCSV(bytes.NewReader(data), m.WorkingDir, m.lenientCSV())
+		if err != nil {
+			backups[i].Err = err
+			continue
+		}
+		backups[i].Diff = bulk.DiffImages(images, currentImages)
+	}
+	return nil
+}
+
+func backupsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		serveBackups(w, r)
+	case "POST":
+		handleRestore(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveBackups(w http.ResponseWriter, r *http.Request) {
+	renderBackups(w, currentModel())
+}
+
+// renderBackups lists the backups of the CSV file of m.
+func renderBackups(w http.ResponseWriter, m *model) {
+	backups, err := findBackups(m.WorkingDir, m.CSVFilename)
+	if err != nil {
+		m.Err = fmt.Errorf("Error: could not list backups: %v", err)
+	} else if err := diffBackups(m, backups); err != nil {
+		m.Err = fmt.Errorf("Error: could not compare backups to CSV file: %v", err)
+	}
+	m.Backups = backups
+
+	render(w, backupsTmpl, m)
+}
+
+// handleRestore replaces the CSV file with the posted backup. The replaced
+// content is backed up too and the restore is recorded in the journal so it
+// can be undone. Like a save, it fails if the CSV file was changed by another
+// program since it was last read.
+func handleRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.PostFormValue("backup")
+	badBackup := false
+	m, err := updateModel(func(m *model) error {
+		data, err := readBackup(m.WorkingDir, m.CSVFilename, name)
+		if err != nil {
+			badBackup = true
+			return fmt.Errorf("could not read backup: %v", err)
+		}
+		changed, err := csvChanged(m)
+		if err != nil {
+			return fmt.Errorf("Error: could not check CSV file for changes: %v", err)
+		}
+		if changed {
+			return fmt.Errorf("Error: could not restore backup: %v; open the images page to load it first", errCSVChanged)
+		}
+		old, err := ioutil.ReadFile(filepath.Join(m.WorkingDir, m.CSVFilename))
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("Error: could not read CSV file: %v", err)
+		}
+		if err := writeCSVFile(m.WorkingDir, m.CSVFilename, data); err != nil {
+			return fmt.Errorf("Error: could not restore backup: %v", err)
+		}
+		if err := m.reload(); err != nil {
+			return fmt.Errorf("Error: could not load from CSV File: %w", err)
+		}
+		if err := journalChanges(m, old); err != nil {
+			return fmt.Errorf("Error: could not write journal: %v", err)
+		}
+		return nil
+	})
+	if badBackup {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		m.Err = err
+		renderBackups(w, m)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
@@ -28,28 +28,89 @@ type Image struct {
 	Tags   []string
 	Source string
 	Rating string
+	// Content reports whether the file content agrees with its extension
+	// and Detected is the media type found in the content. Both are set by
+	// CheckContent.
+	Content  Content
+	Detected string
+	// MD5 and SHA1 are the hex encoded hashes of the file content, set by
+	// HashImages.
+	MD5  string
+	SHA1 string
+	// PHash is the hex encoded perceptual hash of the image, set by
+	// PerceptualHashImages for images that can be decoded.
+	PHash string
+	// Extra holds the values of unknown columns of a CSV file read in
+	// lenient mode. They are written back after the known columns by Save.
+	Extra []string
 }
 
-var supportedExt = []string{"gif", "jpeg", "jpg", "png", "swf"}
+// Field is an editable metadata field of an image.
+type Field string
 
-func isSupportedType(name string) bool {
-	fname := strings.ToLower(name)
-	for _, ext := range supportedExt {
-		// The only possible returned error is ErrBadPattern, when pattern is
-		// malformed. Patterns like *.jpg are never malformed so we ignore the
-		// error.
-		matches, _ := filepath.Match("*."+ext, fname)
-		if matches {
-			return true
+// The editable metadata fields.
+const (
+	FieldTags   Field = "tags"
+	FieldSource Field = "source"
+	FieldRating Field = "rating"
+)
+
+// CopyFields copies the provided metadata fields of src to dst.
+func CopyFields(dst *Image, src Image, fields ...Field) {
+	for _, f := range fields {
+		switch f {
+		case FieldTags:
+			dst.Tags = append([]string(nil), src.Tags...)
+		case FieldSource:
+			dst.Source = src.Source
+		case FieldRating:
+			dst.Rating = src.Rating
+		}
+	}
+}
+
+// FieldValue returns the value of a metadata field of img as text. Tags are
+// joined by spaces.
+func FieldValue(img Image, f Field) string {
+	switch f {
+	case FieldTags:
+		tags := make([]string, 0, len(img.Tags))
+		for _, t := range img.Tags {
+			if t != "" {
+				tags = append(tags, t)
+			}
 		}
+		return strings.Join(tags, " ")
+	case FieldSource:
+		return img.Source
+	case FieldRating:
+		return img.Rating
 	}
-	return false
+	return ""
+}
+
+// SetField sets a metadata field of img from its text value as returned by
+// FieldValue.
+func SetField(img *Image, f Field, value string) {
+	switch f {
+	case FieldTags:
+		img.Tags = SplitTags(value)
+	case FieldSource:
+		img.Source = value
+	case FieldRating:
+		img.Rating = value
+	}
+}
+
+func isSupportedType(name string) bool {
+	_, ok := MediaTypes.Lookup(name)
+	return ok
 }
 
 // LoadImages expects a slice of directory entries (os.FileInfo) which is the
 // result of a read directory like ioutil.ReadDir. It loops through the slice,
-// ignoring any directory and keeps only the files with one of the following
-// extensions: "gif", "jpeg", "jpg", "png", "swf"
+// ignoring any directory and keeps only the files with one of the extensions
+// of the MediaTypes registry.
 //
 // It returns a slice of images without metadata, using the filename as Name
 // and the order the files were found as an increasing ID starting from 0.
@@ -72,42 +133,94 @@ func LoadImages(files []os.FileInfo) []Image {
 	return images
 }
 
-// LoadCSV loads the image metadata from a CSV file that is open for reading.
-// The metadata are returned as slice of images and should be combined with the
-// slice of images discovered by LoadImages by calling Combine.
-func LoadCSV(file io.Reader) ([]Image, error) {
+// WalkImages walks the directory tree rooted at dir and keeps every file with
+// a supported extension, the same way LoadImages does for a single directory.
+// Hidden directories (starting with a dot) are skipped.
+//
+// The Name of each returned image is its path relative to dir, always using
+// '/' as separator, for example "artist/series/pic1.png". IDs are assigned in
+// the lexical order the files are walked, starting from 0.
+func WalkImages(dir string) ([]Image, error) {
 	images := []Image{}
 
-	r := csv.NewReader(file)
-	for {
-		record, err := r.Read()
-		if err == io.EOF {
-			break
+	id := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
 		}
+		if info.IsDir() {
+			if path != dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isSupportedType(info.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
 		if err != nil {
-			return nil, err
+			return err
 		}
+		images = append(images, Image{ID: id, Name: filepath.ToSlash(rel)})
+		id++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// LoadCSV loads the image metadata from a CSV file that is open for reading.
+// The metadata are returned as slice of images and should be combined with the
+// slice of images discovered by LoadImages by calling Combine.
+//
+// If the file does not have the expected format, a *ValidationError listing
+// every problem found is returned.
+func LoadCSV(file io.Reader) ([]Image, error) {
+	return NewReader(file).ReadAll()
+}
 
-		if len(record) != 5 {
-			return nil, fmt.Errorf("invalid csv file format")
+// LoadCSVRecursive is like LoadCSV but instead of keeping only the base of
+// each image path, it keeps the path relative to the base of the provided
+// working directory dir. It is meant to be combined with the images discovered
+// by WalkImages.
+//
+// As an example if dir is '/localpath/pics' and a record has the path
+// '/serverpath/pics/artist/pic1.jpg' then the image Name will be
+// 'artist/pic1.jpg'. The prefix, '/serverpath' in the example, is found from
+// the first record as described for Reader.Prefix.
+func LoadCSVRecursive(file io.Reader, dir string) ([]Image, error) {
+	r := NewReader(file)
+	r.Dir = dir
+	return r.ReadAll()
+}
+
+// findPrefix returns the part of serverPath before the first folder named
+// base, without the separator that follows it. Both '/' and '\' are accepted
+// as separators since the CSV file might have been written on any platform.
+func findPrefix(serverPath, base string) (string, bool) {
+	start := 0
+	for i := 0; i < len(serverPath); i++ {
+		if serverPath[i] != '/' && serverPath[i] != '\\' {
+			continue
 		}
-		// Image filepath (first column) should exist otherwise we cannot match
-		// the metadata with the images found under the directory.
-		if record[0] != "" {
-			img := Image{
-				Name:   filepath.Base(record[0]),
-				Tags:   strings.Split(record[1], " "),
-				Source: record[2],
-				Rating: record[3],
+		if serverPath[start:i] == base {
+			prefix := serverPath[:start]
+			// A root prefix keeps its separator.
+			if len(prefix) > 1 {
+				prefix = prefix[:len(prefix)-1]
 			}
-			images = append(images, img)
+			return prefix, true
 		}
+		start = i + 1
 	}
-	return images, nil
+	return "", false
 }
 
 // Combine takes the metadata of imagesWithInfo and copies them to images
-// returning the combined result.
+// returning the combined result. Images are matched by Name which is either
+// the filename or, when working recursively, the relative path of the image.
 func Combine(images, imagesWithInfo []Image) []Image {
 	for _, info := range imagesWithInfo {
 		if info.Name == "" {
@@ -118,6 +231,7 @@ func Combine(images, imagesWithInfo []Image) []Image {
 			img.Source = info.Source
 			img.Rating = info.Rating
 			img.Tags = info.Tags
+			img.Extra = info.Extra
 		}
 	}
 	return images
@@ -162,6 +276,9 @@ func FindByID(image []Image, id int) *Image {
 // As an example if the provided workingDir path is '/localpath/pics' and the
 // first line has '/serverpath/pics/pic1' then the returned current prefix will
 // be '/serverpath'.
+//
+// Deprecated: the prefix cannot be found when the working directory was
+// renamed. Use path rules with Writer.Paths and Reader.Paths instead.
 func CurrentPrefix(workingDir string, file io.Reader) (string, error) {
 	r := csv.NewReader(file)
 	firstLine, err := r.Read()
@@ -171,12 +288,21 @@ func CurrentPrefix(workingDir string, file io.Reader) (string, error) {
 	if err != nil {
 		return "", err
 	}
+	return PathPrefix(workingDir, firstLine[0]), nil
+}
 
-	serverDir := firstLine[0]
+// PathPrefix uses the base directory of the provided workingDir path to find
+// the path prefix of serverPath, the path of an image in a CSV file, the same
+// way as CurrentPrefix.
+//
+// Deprecated: the prefix cannot be found when the working directory was
+// renamed. Use path rules with Writer.Paths and Reader.Paths instead.
+func PathPrefix(workingDir, serverPath string) string {
+	serverDir := serverPath
 	picFolder := filepath.Base(workingDir)
 	sep := fmt.Sprintf("%c", filepath.Separator)
 	if !strings.Contains(serverDir, picFolder) {
-		return sep, nil
+		return sep
 	}
 	for {
 		if filepath.Base(serverDir) == picFolder || serverDir == "" || serverDir == "." {
@@ -186,84 +312,114 @@ func CurrentPrefix(workingDir string, file io.Reader) (string, error) {
 			serverDir = filepath.Dir(serverDir)
 		}
 	}
-	return filepath.Dir(serverDir), nil
+	return filepath.Dir(serverDir)
 }
 
 func sortTags(tags []string) []string {
-	seen := make(map[string]struct{}, len(tags))
-	sort.Strings(tags)
-	var (
-		all       = make([]string, 0)
-		series    = make([]string, 0)
-		character = make([]string, 0)
-		artist    = make([]string, 0)
-		tk        = make([]string, 0)
-		normal    = make([]string, 0)
-	)
-	for _, t := range tags {
-		if _, ok := seen[t]; ok {
-			continue
-		}
-		seen[t] = struct{}{}
-		switch {
-		case strings.HasPrefix(t, "series:"):
-			series = append(series, t)
-		case strings.HasPrefix(t, "character:"):
-			character = append(character, t)
-		case strings.HasPrefix(t, "artist:"):
-			artist = append(artist, t)
-		case strings.HasPrefix(t, "tk:"):
-			tk = append(tk, t)
-		default:
-			normal = append(normal, t)
-		}
-	}
-	all = append(all, series...)
-	all = append(all, character...)
-	all = append(all, artist...)
-	all = append(all, tk...)
-	all = append(all, normal...)
-	return all
+	return TagCategories.SortTags(tags)
 }
 
 // Save will write the image metadata to an open for writing file. It will
 // keep the base of the dir path and replace the prefix with the provided one.
+// Images with a relative path as Name (see WalkImages) are written under the
+// same subdirectories below the base of dir.
+//
+// Save is a shortcut for a Writer without path rules.
 func Save(file io.Writer, images []Image, dir, prefix string, useLinuxSep bool) error {
-	// Sort each image's tags.
-	sorted := make([]Image, 0, len(images))
+	w := NewWriter(file)
+	w.Dir = dir
+	w.Prefix = prefix
+	w.UseLinuxSep = useLinuxSep
+	return w.WriteAll(images)
+}
+
+// Writer writes image metadata to a CSV file in the format expected by the
+// 'Bulk Add CSV' extension.
+type Writer struct {
+	// Dir is the working directory that holds the images.
+	Dir string
+	// Paths maps the local paths of the images to their server paths. If
+	// set, Prefix and UseLinuxSep are not used.
+	Paths PathMap
+	// Prefix replaces the path of Dir, keeping its base, when there are no
+	// path rules.
+	Prefix      string
+	UseLinuxSep bool
+	// Header, if set, is written as the first row. It should name the
+	// columns in the order they are written, like Reader.Header.
+	Header []string
+
+	w io.Writer
+}
+
+// NewWriter returns a new Writer that writes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// WriteAll writes the metadata of images sorting the tags of each image. If
+// there are path rules, every image must be under one of them.
+func (w *Writer) WriteAll(images []Image) error {
+	records := make([][]string, 0, len(images)+1)
+	if w.Header != nil {
+		records = append(records, w.Header)
+	}
 	for _, img := range images {
 		img.Tags = sortTags(img.Tags)
-		sorted = append(sorted, img)
+		record, err := w.toRecord(img)
+		if err != nil {
+			return err
+		}
+		// Images without the extra columns get empty ones so that every
+		// row has the columns of the header.
+		for len(record) < len(w.Header) {
+			record = append(record, "")
+		}
+		records = append(records, record)
 	}
-	// Save to file.
-	w := csv.NewWriter(file)
-	w.WriteAll(toRecords(sorted, dir, prefix, useLinuxSep))
+	cw := csv.NewWriter(w.w)
+	cw.WriteAll(records)
 
-	if err := w.Error(); err != nil {
+	if err := cw.Error(); err != nil {
 		return fmt.Errorf("error writing csv: %v", err)
 	}
 	return nil
 }
 
-func toRecords(images []Image, dir, prefix string, useLinuxSep bool) [][]string {
-	var records [][]string
-	for _, img := range images {
-		record := toRecord(img, dir, prefix, useLinuxSep)
-		records = append(records, record)
+// ServerPath returns the path written for img in the CSV file.
+func (w *Writer) ServerPath(img Image) (string, error) {
+	if len(w.Paths) != 0 {
+		local := filepath.Join(w.Dir, filepath.FromSlash(img.Name))
+		p, ok := w.Paths.ServerPath(local)
+		if !ok {
+			return "", fmt.Errorf("no path rule for %v", local)
+		}
+		return p, nil
+	}
+	p := filepath.Join(w.Prefix, filepath.Base(w.Dir), img.Name)
+	if w.UseLinuxSep {
+		p = filepath.ToSlash(p)
 	}
-	return records
+	return p, nil
 }
 
-func toRecord(img Image, dir, prefix string, useLinuxSep bool) []string {
-	var record []string
-	p := filepath.Join(prefix, filepath.Base(dir), img.Name)
-	if useLinuxSep {
-		p = filepath.ToSlash(p)
+func (w *Writer) toRecord(img Image) ([]string, error) {
+	p, err := w.ServerPath(img)
+	if err != nil {
+		return nil, err
+	}
+	// Tags with commas would not be loaded back.
+	for _, t := range img.Tags {
+		if strings.Contains(t, ",") {
+			return nil, fmt.Errorf("%v: tag %q contains a comma", img.Name, t)
+		}
 	}
+	var record []string
 	record = append(record, p)
 	record = append(record, strings.Join(img.Tags, " "))
 	record = append(record, img.Source)
 	record = append(record, img.Rating)
 	record = append(record, "")
-	return record
+	record = append(record, img.Extra...)
+	return record, nil
 }
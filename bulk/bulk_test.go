@@ -2,9 +2,9 @@ package bulk_test
 
 import (
 	"bytes"
-	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -15,7 +15,6 @@ import (
 	"github.com/kusubooru/tagaa/bulk"
 )
 
-var errInvalidFormat = errors.New("invalid csv file format")
 var loadCSVTests = []struct {
 	in   string
 	out  []bulk.Image
@@ -23,8 +22,45 @@ var loadCSVTests = []struct {
 }{
 	{"", []bulk.Image{}, nil},
 	{",,,,", []bulk.Image{}, nil},
-	{",,,,,,,,,,,,,,,,,,,", []bulk.Image(nil), errInvalidFormat},
-	{"invalid format", []bulk.Image(nil), errInvalidFormat},
+	{
+		",,,,,,,,,,,,,,,,,,,",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{{Line: 1, Reason: "expected 5 fields, got 20"}}},
+	},
+	{
+		"invalid format",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{{Line: 1, Reason: "expected 5 fields, got 1"}}},
+	},
+	{
+		"/server/path/img1,tag1,source,s,\n" +
+			"/server/path/img2,tag1,source,x,\n" +
+			"/server/path/img1,\"tag1 a,b\",source,q,\n" +
+			"/server/path/img3,tag1,source\n",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 2, Column: 4, Reason: `unknown rating "x", expected one of ["s" "q" "e"]`},
+			{Line: 3, Column: 1, Reason: `duplicate image path "/server/path/img1", first seen on line 1`},
+			{Line: 3, Column: 2, Reason: `tag "a,b" contains a comma`},
+			{Line: 4, Reason: "expected 5 fields, got 3"},
+		}},
+	},
+	{
+		"/server/path/img1,tag1,source,s,\n" +
+			"/server/path/img2,\"tag1,source,s,\n",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 2, Reason: `extraneous or missing " in quoted-field at character 35`},
+		}},
+	},
+	{
+		"/server/path/img1,tag1,source,s,\n" +
+			"/server/other/img1,tag1,source,s,\n",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 2, Column: 1, Reason: `path "/server/other/img1" is image "img1" again, first seen on line 1`},
+		}},
+	},
 	{",,,,\n,,,,\n,,,,", []bulk.Image{}, nil},
 	{
 		"/server/path/img1,tag1 tag2,source,s,",
@@ -66,6 +102,113 @@ func TestLoadCSV_readFail(t *testing.T) {
 	}
 }
 
+var loadCSVRecursiveTests = []struct {
+	indir string
+	in    string
+	out   []bulk.Image
+	oerr  error
+}{
+	{"/local/path/dir", "", []bulk.Image{}, nil},
+	{
+		"/local/path/dir",
+		"/server/path/dir/img1,tag1,source,s,",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	{
+		"/local/path/dir",
+		"/server/path/dir/artist/series/img1,tag1,source,s,",
+		[]bulk.Image{{Name: "artist/series/img1", Tags: []string{"tag1"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	{
+		"/local/path/dir",
+		`C:\server\dir\artist\img1,tag1,source,s,`,
+		[]bulk.Image{{Name: "artist/img1", Tags: []string{"tag1"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	{
+		"/local/path/dir",
+		"/dir/img1,tag1,source,s,",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	{
+		"/local/path/pics",
+		"/server/pics/pics/1.png,tag1,source,s,\n" +
+			"/server/pics/2.png,tag2,source,s,",
+		[]bulk.Image{
+			{Name: "pics/1.png", Tags: []string{"tag1"}, Source: "source", Rating: "s"},
+			{Name: "2.png", Tags: []string{"tag2"}, Source: "source", Rating: "s"},
+		},
+		nil,
+	},
+	{
+		"/local/path/dir",
+		"/server/path/other/img1,tag1,source,s,",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 1, Column: 1, Reason: `path "/server/path/other/img1" is not under a folder named "dir"`},
+		}},
+	},
+	{
+		"/local/path/dir",
+		"/server/path/dir/img1,tag1,source,s,\n" +
+			"/server/other/dir/img2,tag2,source,s,",
+		[]bulk.Image(nil),
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 2, Column: 1, Reason: `path "/server/other/dir/img2" is not under "/server/path/dir"`},
+		}},
+	},
+}
+
+func TestLoadCSVRecursive(t *testing.T) {
+	for _, tt := range loadCSVRecursiveTests {
+		got, err := bulk.LoadCSVRecursive(strings.NewReader(tt.in), tt.indir)
+		if want := tt.oerr; !reflect.DeepEqual(err, want) {
+			t.Errorf("LoadCSVRecursive(%q, %q) returned err %v, want %v", tt.in, tt.indir, err, want)
+		}
+		if want := tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("LoadCSVRecursive(%q, %q) => %v, want %v", tt.in, tt.indir, got, want)
+		}
+	}
+}
+
+func TestReader_Prefix(t *testing.T) {
+	in := "/server/pics/pics/1.png,tag1,,s,\n" +
+		"/server/other/2.png,tag2,,s,\n"
+	r := bulk.NewReader(strings.NewReader(in))
+	r.Dir = "/local/pics"
+	r.Prefix = "/server"
+	_, err := r.ReadAll()
+	want := &bulk.ValidationError{Problems: []bulk.Problem{
+		{Line: 2, Column: 1, Reason: `path "/server/other/2.png" is not under "/server/pics"`},
+	}}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("ReadAll with prefix returned err %v, want %v", err, want)
+	}
+
+	r = bulk.NewReader(strings.NewReader("/server/pics/pics/1.png,tag1,,s,\n"))
+	r.Dir = "/local/pics"
+	r.Prefix = "/server/pics"
+	images, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll with prefix returned err: %v", err)
+	}
+	if got, want := images[0].Name, "1.png"; got != want {
+		t.Errorf("ReadAll with prefix %q => name %q, want %q", r.Prefix, got, want)
+	}
+
+	r = bulk.NewReader(strings.NewReader("/server/pics/pics/1.png,tag1,,s,\n"))
+	r.Dir = "/local/pics"
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatalf("ReadAll without prefix returned err: %v", err)
+	}
+	if got, want := r.Prefix, "/server"; got != want {
+		t.Errorf("ReadAll without prefix set Prefix %q, want %q", got, want)
+	}
+}
+
 var currentPrefixTests = []struct {
 	indir  string
 	infile string
@@ -187,6 +330,12 @@ var combineTests = []struct {
 		[]bulk.Image{{Name: "img2", Source: "source2"}, {Name: "img1", Source: "source1"}},
 		[]bulk.Image{{Name: "img1", Source: "source1"}, {Name: "img2", Source: "source2"}},
 	},
+	// Same filename in two folders.
+	{
+		[]bulk.Image{{Name: "a/img1"}, {Name: "b/img1"}},
+		[]bulk.Image{{Name: "b/img1", Source: "source2"}, {Name: "a/img1", Source: "source1"}},
+		[]bulk.Image{{Name: "a/img1", Source: "source1"}, {Name: "b/img1", Source: "source2"}},
+	},
 }
 
 func TestCombine(t *testing.T) {
@@ -282,6 +431,45 @@ func TestLoadImages(t *testing.T) {
 	}
 }
 
+func TestWalkImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{
+		"a.jpg",
+		"b.ico",
+		filepath.Join("artist", "series", "a.png"),
+		filepath.Join("artist", "z.gif"),
+		filepath.Join(".hidden", "c.jpg"),
+		filepath.Join("other", "bulk.csv"),
+	}
+	for _, f := range files {
+		p := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := bulk.WalkImages(dir)
+	if err != nil {
+		t.Fatalf("WalkImages(%q) returned err %v", dir, err)
+	}
+	want := []bulk.Image{
+		{ID: 0, Name: "a.jpg"},
+		{ID: 1, Name: "artist/series/a.png"},
+		{ID: 2, Name: "artist/z.gif"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkImages(%q) => %v, want %v", dir, got, want)
+	}
+}
+
 var saveTests = []struct {
 	images   []bulk.Image
 	dir      string
@@ -313,6 +501,13 @@ var saveTests = []struct {
 		false,
 		filepath.Join("/", "server", "path", "dir", "img1") + ",,source1,s,\n" + filepath.Join("/", "server", "path", "dir", "img2") + ",,source2,q,\n",
 	},
+	{
+		[]bulk.Image{{ID: 0, Name: "artist/series/img1", Source: "source1", Rating: "s"}},
+		filepath.Join("/", "local", "path", "dir"),
+		filepath.Join("/", "server", "path"),
+		true,
+		"/server/path/dir/artist/series/img1,,source1,s,\n",
+	},
 }
 
 func TestSave(t *testing.T) {
@@ -367,3 +562,24 @@ func (e *errReader) Read(p []byte) (n int, err error) {
 	err = e.err
 	return
 }
+
+func TestSave_loadCSV(t *testing.T) {
+	img := bulk.Image{Name: "img1.jpg", Rating: "s"}
+	bulk.SetField(&img, bulk.FieldTags, "foo,bar baz")
+	var b bytes.Buffer
+	if err := bulk.Save(&b, []bulk.Image{img}, "/local/path/dir", "/server/path", true); err != nil {
+		t.Fatalf("Save returned err: %v", err)
+	}
+	images, err := bulk.LoadCSV(&b)
+	if err != nil {
+		t.Fatalf("LoadCSV of saved file returned err: %v", err)
+	}
+	if want := []string{"bar", "baz", "foo"}; len(images) != 1 || !reflect.DeepEqual(images[0].Tags, want) {
+		t.Errorf("LoadCSV of saved file => %+v, want tags %q", images, want)
+	}
+
+	img.Tags = []string{"foo,bar"}
+	if err := bulk.Save(&b, []bulk.Image{img}, "/local/path/dir", "/server/path", true); err == nil {
+		t.Errorf("Save of tag with comma expected to return err")
+	}
+}
@@ -0,0 +1,122 @@
+package bulk
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Category is a kind of tag recognized by its prefix, like "artist:".
+type Category struct {
+	// Name is a short unique name for the category like "artist". It is
+	// also used as CSS class by the web interface so it may only have
+	// letters, digits, dashes and underscores.
+	Name string `json:"name"`
+	// Prefix is the start of the tags of the category, including the
+	// trailing colon.
+	Prefix string `json:"prefix"`
+	// Order is where the tags of the category go when sorted, lower first.
+	// Tags without category always go last.
+	Order int `json:"order"`
+	// Color is the CSS color of the tags of the category.
+	Color string `json:"color"`
+}
+
+// Categories is a set of tag categories.
+type Categories []Category
+
+// DefaultCategories are the tag categories of Kusubooru.
+var DefaultCategories = Categories{
+	{Name: "series", Prefix: "series:", Order: 1, Color: "#a0a"},
+	{Name: "character", Prefix: "character:", Order: 2, Color: "#0a0"},
+	{Name: "artist", Prefix: "artist:", Order: 3, Color: "#a00"},
+	{Name: "tk", Prefix: "tk:", Order: 4, Color: "#ee5542"},
+}
+
+// TagCategories are the tag categories used to sort tags by Save, to collapse
+// prefixes by the Normalizer and to categorize autocomplete suggestions.
+var TagCategories = DefaultCategories
+
+var validCategoryName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Validate reports the first category with an invalid or repeated name or
+// prefix.
+func (cs Categories) Validate() error {
+	names := make(map[string]struct{}, len(cs))
+	prefixes := make(map[string]struct{}, len(cs))
+	for _, c := range cs {
+		if !validCategoryName.MatchString(c.Name) {
+			return fmt.Errorf("category %q: name may only have letters, digits, dashes and underscores", c.Name)
+		}
+		if !strings.HasSuffix(c.Prefix, ":") {
+			return fmt.Errorf("category %q: prefix %q must end with a colon", c.Name, c.Prefix)
+		}
+		if _, ok := names[c.Name]; ok {
+			return fmt.Errorf("category %q is defined more than once", c.Name)
+		}
+		if _, ok := prefixes[c.Prefix]; ok {
+			return fmt.Errorf("category %q: prefix %q is used by another category", c.Name, c.Prefix)
+		}
+		names[c.Name] = struct{}{}
+		prefixes[c.Prefix] = struct{}{}
+	}
+	return nil
+}
+
+// Of returns the category of tag. When more than one prefix matches, the
+// longest wins.
+func (cs Categories) Of(tag string) (Category, bool) {
+	var (
+		found Category
+		ok    bool
+	)
+	for _, c := range cs {
+		if strings.HasPrefix(tag, c.Prefix) && len(c.Prefix) > len(found.Prefix) {
+			found, ok = c, true
+		}
+	}
+	return found, ok
+}
+
+// Prefixes returns the prefixes of the categories.
+func (cs Categories) Prefixes() []string {
+	prefixes := make([]string, 0, len(cs))
+	for _, c := range cs {
+		prefixes = append(prefixes, c.Prefix)
+	}
+	return prefixes
+}
+
+// SortTags returns the tags without duplicates, grouped by category in the
+// order of the categories and sorted alphabetically inside each group.
+func (cs Categories) SortTags(tags []string) []string {
+	ordered := make(Categories, len(cs))
+	copy(ordered, cs)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Order < ordered[j].Order })
+	group := make(map[string]int, len(ordered))
+	for i, c := range ordered {
+		group[c.Name] = i
+	}
+
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+	seen := make(map[string]struct{}, len(sorted))
+	groups := make([][]string, len(ordered)+1)
+	for _, t := range sorted {
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		i := len(ordered)
+		if c, ok := cs.Of(t); ok {
+			i = group[c.Name]
+		}
+		groups[i] = append(groups[i], t)
+	}
+	all := make([]string, 0, len(seen))
+	for _, g := range groups {
+		all = append(all, g...)
+	}
+	return all
+}
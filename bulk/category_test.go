@@ -0,0 +1,83 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestCategories_SortTags(t *testing.T) {
+	tags := []string{"b", "tk:x", "artist:b", "a", "series:s", "artist:a", "character:c", "a"}
+	got := bulk.DefaultCategories.SortTags(tags)
+	want := []string{"series:s", "character:c", "artist:a", "artist:b", "tk:x", "a", "b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortTags(%q) => %q, want %q", tags, got, want)
+	}
+}
+
+func TestCategories_SortTags_custom(t *testing.T) {
+	cs := bulk.Categories{
+		{Name: "meta", Prefix: "meta:", Order: 3},
+		{Name: "circle", Prefix: "circle:", Order: 1},
+		{Name: "copyright", Prefix: "copyright:", Order: 2},
+	}
+	tags := []string{"meta:translated", "tag", "copyright:c", "artist:a", "circle:b"}
+	got := cs.SortTags(tags)
+	want := []string{"circle:b", "copyright:c", "meta:translated", "artist:a", "tag"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SortTags(%q) => %q, want %q", tags, got, want)
+	}
+}
+
+func TestCategories_Of(t *testing.T) {
+	cs := bulk.Categories{
+		{Name: "meta", Prefix: "meta:"},
+		{Name: "metaseries", Prefix: "meta:series:"},
+	}
+	tests := []struct {
+		tag  string
+		name string
+		ok   bool
+	}{
+		{"meta:translated", "meta", true},
+		{"meta:series:x", "metaseries", true},
+		{"metadata", "", false},
+		{"tag", "", false},
+	}
+	for _, tt := range tests {
+		c, ok := cs.Of(tt.tag)
+		if c.Name != tt.name || ok != tt.ok {
+			t.Errorf("Of(%q) => %q, %v, want %q, %v", tt.tag, c.Name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestCategories_Validate(t *testing.T) {
+	tests := []struct {
+		cs    bulk.Categories
+		valid bool
+	}{
+		{bulk.DefaultCategories, true},
+		{bulk.Categories{{Name: "meta", Prefix: "meta:"}}, true},
+		{bulk.Categories{{Name: "meta tags", Prefix: "meta:"}}, false},
+		{bulk.Categories{{Name: "", Prefix: "meta:"}}, false},
+		{bulk.Categories{{Name: "meta", Prefix: "meta"}}, false},
+		{bulk.Categories{{Name: "meta", Prefix: "meta:"}, {Name: "meta", Prefix: "m:"}}, false},
+		{bulk.Categories{{Name: "meta", Prefix: "meta:"}, {Name: "m", Prefix: "meta:"}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.cs.Validate(); (err == nil) != tt.valid {
+			t.Errorf("%+v Validate() => %v, want valid %v", tt.cs, err, tt.valid)
+		}
+	}
+}
+
+func TestNormalizer_Tag_categories(t *testing.T) {
+	defer func(cs bulk.Categories) { bulk.TagCategories = cs }(bulk.TagCategories)
+	bulk.TagCategories = bulk.Categories{{Name: "meta", Prefix: "meta:"}}
+
+	if got, want := bulk.FullNormalizer.Tag("Meta : translated"), "meta:translated"; got != want {
+		t.Errorf("Tag with meta category => %q, want %q", got, want)
+	}
+}
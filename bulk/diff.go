@@ -0,0 +1,112 @@
+package bulk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Diff holds the differences between two versions of the image metadata.
+// Images are matched by Name.
+type Diff struct {
+	// Added are the images only found in the new version.
+	Added []Image
+	// Removed are the images only found in the old version.
+	Removed []Image
+	// Changed are the images found in both versions with different
+	// metadata.
+	Changed []Change
+}
+
+// Change is an image whose metadata differ between two versions.
+type Change struct {
+	Old, New Image
+	// Fields are the metadata fields that differ.
+	Fields []Field
+}
+
+// DiffImages compares the metadata of two versions of images. The order of the
+// tags and empty tags are not taken into account. The results are sorted by
+// name.
+func DiffImages(old, new []Image) Diff {
+	var d Diff
+	olds := make(map[string]Image, len(old))
+	for _, img := range old {
+		olds[img.Name] = img
+	}
+	news := make(map[string]struct{}, len(new))
+	for _, img := range new {
+		news[img.Name] = struct{}{}
+		o, ok := olds[img.Name]
+		if !ok {
+			d.Added = append(d.Added, img)
+			continue
+		}
+		if fields := ChangedFields(o, img); len(fields) != 0 {
+			d.Changed = append(d.Changed, Change{Old: o, New: img, Fields: fields})
+		}
+	}
+	for _, img := range old {
+		if _, ok := news[img.Name]; !ok {
+			d.Removed = append(d.Removed, img)
+		}
+	}
+	sort.Sort(byName(d.Added))
+	sort.Sort(byName(d.Removed))
+	sort.Slice(d.Changed, func(i, j int) bool { return d.Changed[i].New.Name < d.Changed[j].New.Name })
+	return d
+}
+
+// ChangedFields returns the metadata fields that differ between a and b.
+func ChangedFields(a, b Image) []Field {
+	var fields []Field
+	if !sameTags(a.Tags, b.Tags) {
+		fields = append(fields, FieldTags)
+	}
+	if a.Source != b.Source {
+		fields = append(fields, FieldSource)
+	}
+	if a.Rating != b.Rating {
+		fields = append(fields, FieldRating)
+	}
+	return fields
+}
+
+// sameTags reports whether a and b have the same tags regardless of their
+// order, duplicates and empty tags.
+func sameTags(a, b []string) bool {
+	sa, sb := tagSet(a), tagSet(b)
+	if len(sa) != len(sb) {
+		return false
+	}
+	for t := range sa {
+		if _, ok := sb[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty reports whether there are no differences.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// String returns a short summary of the differences like "1 added, 2
+// changed".
+func (d Diff) String() string {
+	if d.Empty() {
+		return "no changes"
+	}
+	var parts []string
+	if n := len(d.Added); n != 0 {
+		parts = append(parts, fmt.Sprintf("%d added", n))
+	}
+	if n := len(d.Removed); n != 0 {
+		parts = append(parts, fmt.Sprintf("%d removed", n))
+	}
+	if n := len(d.Changed); n != 0 {
+		parts = append(parts, fmt.Sprintf("%d changed", n))
+	}
+	return strings.Join(parts, ", ")
+}
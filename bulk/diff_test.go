@@ -0,0 +1,59 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestDiffImages(t *testing.T) {
+	old := []bulk.Image{
+		{Name: "same", Tags: []string{"a", "b"}, Rating: "s"},
+		{Name: "reordered", Tags: []string{"a", "b", ""}},
+		{Name: "tags", Tags: []string{"a"}},
+		{Name: "meta", Tags: []string{"a"}, Source: "x", Rating: "s"},
+		{Name: "removed"},
+	}
+	new := []bulk.Image{
+		{Name: "added", Tags: []string{"a"}},
+		{Name: "meta", Tags: []string{"a"}, Source: "y", Rating: "q"},
+		{Name: "tags", Tags: []string{"a", "b"}},
+		{Name: "reordered", Tags: []string{"b", "a"}},
+		{Name: "same", Tags: []string{"a", "b"}, Rating: "s"},
+	}
+	got := bulk.DiffImages(old, new)
+	want := bulk.Diff{
+		Added:   []bulk.Image{{Name: "added", Tags: []string{"a"}}},
+		Removed: []bulk.Image{{Name: "removed"}},
+		Changed: []bulk.Change{
+			{
+				Old:    bulk.Image{Name: "meta", Tags: []string{"a"}, Source: "x", Rating: "s"},
+				New:    bulk.Image{Name: "meta", Tags: []string{"a"}, Source: "y", Rating: "q"},
+				Fields: []bulk.Field{bulk.FieldSource, bulk.FieldRating},
+			},
+			{
+				Old:    bulk.Image{Name: "tags", Tags: []string{"a"}},
+				New:    bulk.Image{Name: "tags", Tags: []string{"a", "b"}},
+				Fields: []bulk.Field{bulk.FieldTags},
+			},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffImages() =>\n%+v\nwant\n%+v", got, want)
+	}
+	if got, want := got.String(), "1 added, 1 removed, 2 changed"; got != want {
+		t.Errorf("Diff.String() => %q, want %q", got, want)
+	}
+}
+
+func TestDiffImages_empty(t *testing.T) {
+	images := []bulk.Image{{Name: "a", Tags: []string{"a"}}}
+	d := bulk.DiffImages(images, images)
+	if !d.Empty() {
+		t.Errorf("DiffImages of the same images => %+v, want empty", d)
+	}
+	if got, want := d.String(), "no changes"; got != want {
+		t.Errorf("Diff.String() => %q, want %q", got, want)
+	}
+}
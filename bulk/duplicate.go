@@ -0,0 +1,83 @@
+package bulk
+
+import "sort"
+
+// Duplicates groups the images that have identical content, as found by
+// comparing the SHA1 set by HashImages. Only groups of two or more images are
+// returned. Images in a group are sorted by Name and groups are sorted by the
+// Name of their first image.
+func Duplicates(images []Image) [][]Image {
+	bySHA1 := make(map[string][]Image)
+	for _, img := range images {
+		if img.SHA1 == "" {
+			continue
+		}
+		bySHA1[img.SHA1] = append(bySHA1[img.SHA1], img)
+	}
+	var groups [][]Image
+	for _, group := range bySHA1 {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Sort(byName(group))
+		groups = append(groups, group)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i][0].Name < groups[j][0].Name })
+	return groups
+}
+
+// Unique returns the images leaving out duplicates. From each group of images
+// with identical content, only the first by Name is kept.
+func Unique(images []Image) []Image {
+	first := make(map[string]string)
+	for _, img := range images {
+		if img.SHA1 == "" {
+			continue
+		}
+		if name, ok := first[img.SHA1]; !ok || img.Name < name {
+			first[img.SHA1] = img.Name
+		}
+	}
+	unique := make([]Image, 0, len(images))
+	for _, img := range images {
+		if img.SHA1 != "" && first[img.SHA1] != img.Name {
+			continue
+		}
+		unique = append(unique, img)
+	}
+	return unique
+}
+
+// MergeInto merges the metadata of others into keep. Tags are united while the
+// source and rating of keep are only filled in if they are empty.
+func MergeInto(keep *Image, others []Image) {
+	for _, o := range others {
+		keep.Tags = UnionTags(keep.Tags, o.Tags)
+		if keep.Source == "" {
+			keep.Source = o.Source
+		}
+		if keep.Rating == "" {
+			keep.Rating = o.Rating
+		}
+	}
+}
+
+// UnionTags returns the tags of a followed by the tags of b that are not
+// already in a. Empty tags are dropped.
+func UnionTags(a, b []string) []string {
+	seen := make(map[string]struct{}, len(a)+len(b))
+	union := make([]string, 0, len(a)+len(b))
+	for _, tags := range [][]string{a, b} {
+		for _, t := range tags {
+			if t == "" {
+				continue
+			}
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			seen[t] = struct{}{}
+			union = append(union, t)
+		}
+	}
+	return union
+}
@@ -0,0 +1,66 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var duplicatesTests = []struct {
+	in  []bulk.Image
+	out [][]bulk.Image
+}{
+	{nil, nil},
+	{[]bulk.Image{{Name: "a", SHA1: "h1"}, {Name: "b", SHA1: "h2"}}, nil},
+	{[]bulk.Image{{Name: "a"}, {Name: "b"}}, nil},
+	{
+		[]bulk.Image{{Name: "d", SHA1: "h2"}, {Name: "c", SHA1: "h1"}, {Name: "b", SHA1: "h2"}, {Name: "a", SHA1: "h1"}, {Name: "e", SHA1: "h3"}},
+		[][]bulk.Image{
+			{{Name: "a", SHA1: "h1"}, {Name: "c", SHA1: "h1"}},
+			{{Name: "b", SHA1: "h2"}, {Name: "d", SHA1: "h2"}},
+		},
+	},
+}
+
+func TestDuplicates(t *testing.T) {
+	for _, tt := range duplicatesTests {
+		got := bulk.Duplicates(tt.in)
+		if want := tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("Duplicates(%v) => %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+var uniqueTests = []struct {
+	in  []bulk.Image
+	out []bulk.Image
+}{
+	{nil, []bulk.Image{}},
+	{
+		[]bulk.Image{{Name: "b", SHA1: "h1"}, {Name: "c"}, {Name: "a", SHA1: "h1"}, {Name: "d"}},
+		[]bulk.Image{{Name: "c"}, {Name: "a", SHA1: "h1"}, {Name: "d"}},
+	},
+}
+
+func TestUnique(t *testing.T) {
+	for _, tt := range uniqueTests {
+		got := bulk.Unique(tt.in)
+		if want := tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("Unique(%v) => %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestMergeInto(t *testing.T) {
+	keep := bulk.Image{Name: "a", Tags: []string{"t1", "t2"}, Rating: "s"}
+	others := []bulk.Image{
+		{Name: "b", Tags: []string{"t2", "t3"}, Source: "source b", Rating: "e"},
+		{Name: "c", Tags: []string{"", "t4"}, Source: "source c"},
+	}
+	bulk.MergeInto(&keep, others)
+	want := bulk.Image{Name: "a", Tags: []string{"t1", "t2", "t3", "t4"}, Source: "source b", Rating: "s"}
+	if !reflect.DeepEqual(keep, want) {
+		t.Errorf("MergeInto => %v, want %v", keep, want)
+	}
+}
@@ -0,0 +1,166 @@
+package bulk
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Hash holds the hashes of the content of an image file. Size and ModTime
+// are kept so that the hashes can be reused while the file is unchanged.
+type Hash struct {
+	MD5     string    `json:"md5"`
+	SHA1    string    `json:"sha1"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Hashes maps image names to the hashes of their content. It is kept in a
+// project sidecar file next to the CSV file.
+type Hashes map[string]Hash
+
+// LoadHashes reads hashes that were previously written by SaveHashes.
+func LoadHashes(file io.Reader) (Hashes, error) {
+	hashes := make(Hashes)
+	if err := json.NewDecoder(file).Decode(&hashes); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode hashes: %v", err)
+	}
+	return hashes, nil
+}
+
+// SaveHashes writes the hashes of the images to an open for writing file.
+// Images without hashes are skipped.
+func SaveHashes(file io.Writer, images []Image, hashes Hashes) error {
+	out := make(Hashes, len(images))
+	for _, img := range images {
+		if h, ok := hashes[img.Name]; ok && h.SHA1 == img.SHA1 {
+			out[img.Name] = h
+			continue
+		}
+		if img.SHA1 != "" {
+			out[img.Name] = Hash{MD5: img.MD5, SHA1: img.SHA1}
+		}
+	}
+	return out.Save(file)
+}
+
+// Save writes all the hashes to an open for writing file.
+func (h Hashes) Save(file io.Writer) error {
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(h); err != nil {
+		return fmt.Errorf("encode hashes: %v", err)
+	}
+	return nil
+}
+
+// HashImages computes the MD5 and SHA-1 of the content of each image under
+// dir. The provided hashes act as a cache: they are reused for files whose
+// size and modification time have not changed and updated otherwise. Images
+// whose file cannot be read are left without hashes. It reports whether hashes
+// were added or updated.
+func HashImages(dir string, images []Image, hashes Hashes) bool {
+	changed := false
+	for i := range images {
+		images[i].MD5, images[i].SHA1 = "", ""
+		p := filepath.Join(dir, filepath.FromSlash(images[i].Name))
+		info, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		h, ok := hashes[images[i].Name]
+		if !ok || h.Size != info.Size() || !h.ModTime.Equal(info.ModTime()) {
+			h, err = hashFile(p)
+			if err != nil {
+				continue
+			}
+			h.Size = info.Size()
+			h.ModTime = info.ModTime()
+			if hashes != nil {
+				hashes[images[i].Name] = h
+				changed = true
+			}
+		}
+		images[i].MD5 = h.MD5
+		images[i].SHA1 = h.SHA1
+	}
+	return changed
+}
+
+func hashFile(filename string) (Hash, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return Hash{}, err
+	}
+	defer f.Close()
+
+	m := md5.New()
+	s := sha1.New()
+	if _, err := io.Copy(io.MultiWriter(m, s), f); err != nil {
+		return Hash{}, fmt.Errorf("hash %v: %v", filename, err)
+	}
+	return Hash{
+		MD5:  hex.EncodeToString(m.Sum(nil)),
+		SHA1: hex.EncodeToString(s.Sum(nil)),
+	}, nil
+}
+
+// CombineByHash works like Combine but also re-attaches the metadata of
+// imagesWithInfo whose name no longer exists. For those, the hashes recorded
+// under the old name are used to find a renamed image with the same content,
+// as long as that image does not have metadata of its own.
+func CombineByHash(images, imagesWithInfo []Image, hashes Hashes) []Image {
+	return Combine(images, MatchByHash(images, imagesWithInfo, hashes))
+}
+
+// MatchByHash returns the images of imagesWithInfo that belong to one of
+// images, named after it. An image of imagesWithInfo belongs to the image
+// with the same name or, if there is none, to an image with the content
+// recorded in hashes under its name, as long as that image does not have
+// metadata of its own in imagesWithInfo.
+func MatchByHash(images, imagesWithInfo []Image, hashes Hashes) []Image {
+	byName := make(map[string]int, len(images))
+	for i := range images {
+		byName[images[i].Name] = i
+	}
+	named := make(map[string]struct{}, len(imagesWithInfo))
+	for _, info := range imagesWithInfo {
+		named[info.Name] = struct{}{}
+	}
+	var matched []Image
+	for _, info := range imagesWithInfo {
+		if info.Name == "" {
+			continue
+		}
+		if _, ok := byName[info.Name]; ok {
+			matched = append(matched, info)
+		}
+	}
+	for _, info := range imagesWithInfo {
+		if _, ok := byName[info.Name]; info.Name == "" || ok {
+			continue
+		}
+		h, ok := hashes[info.Name]
+		if !ok || h.SHA1 == "" {
+			continue
+		}
+		for i := range images {
+			if _, ok := named[images[i].Name]; ok {
+				continue
+			}
+			if images[i].SHA1 == h.SHA1 {
+				info.Name = images[i].Name
+				matched = append(matched, info)
+				named[images[i].Name] = struct{}{}
+				break
+			}
+		}
+	}
+	return matched
+}
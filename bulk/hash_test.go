@@ -0,0 +1,123 @@
+package bulk_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestHashImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.png"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	images := []bulk.Image{{Name: "a.png"}, {Name: "missing.png"}}
+	hashes := make(bulk.Hashes)
+	if !bulk.HashImages(dir, images, hashes) {
+		t.Errorf("HashImages of new file reported no change")
+	}
+	const (
+		wantMD5  = "5d41402abc4b2a76b9719d911017c592"
+		wantSHA1 = "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"
+	)
+	if images[0].MD5 != wantMD5 || images[0].SHA1 != wantSHA1 {
+		t.Errorf("HashImages => md5 %q sha1 %q, want md5 %q sha1 %q", images[0].MD5, images[0].SHA1, wantMD5, wantSHA1)
+	}
+	if got := hashes["a.png"].SHA1; got != wantSHA1 {
+		t.Errorf("HashImages cached sha1 %q, want %q", got, wantSHA1)
+	}
+	// A file that cannot be read is left without hashes.
+	if images[1].SHA1 != "" {
+		t.Errorf("HashImages of missing file => sha1 %q, want empty", images[1].SHA1)
+	}
+	if _, ok := hashes["missing.png"]; ok {
+		t.Errorf("HashImages cached hash of missing file")
+	}
+
+	// A cached hash is reused while size and modification time match.
+	h := hashes["a.png"]
+	h.SHA1 = "cached"
+	hashes["a.png"] = h
+	if bulk.HashImages(dir, images, hashes) {
+		t.Errorf("HashImages of cached file reported a change")
+	}
+	if got, want := images[0].SHA1, "cached"; got != want {
+		t.Errorf("HashImages did not use cache, got sha1 %q, want %q", got, want)
+	}
+}
+
+func TestSaveLoadHashes(t *testing.T) {
+	images := []bulk.Image{{Name: "a.png", MD5: "m1", SHA1: "s1"}, {Name: "b.png"}}
+	var buf bytes.Buffer
+	if err := bulk.SaveHashes(&buf, images, nil); err != nil {
+		t.Fatalf("SaveHashes returned err %v", err)
+	}
+	got, err := bulk.LoadHashes(&buf)
+	if err != nil {
+		t.Fatalf("LoadHashes returned err %v", err)
+	}
+	want := bulk.Hashes{"a.png": {MD5: "m1", SHA1: "s1"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadHashes => %v, want %v", got, want)
+	}
+
+	if got, err := bulk.LoadHashes(strings.NewReader("")); err != nil || len(got) != 0 {
+		t.Errorf("LoadHashes(%q) => %v, %v, want empty hashes", "", got, err)
+	}
+}
+
+var combineByHashTests = []struct {
+	images   []bulk.Image
+	metadata []bulk.Image
+	hashes   bulk.Hashes
+	out      []bulk.Image
+}{
+	// Renamed file gets the metadata of its old name.
+	{
+		[]bulk.Image{{Name: "new", SHA1: "h1"}},
+		[]bulk.Image{{Name: "old", Source: "source"}},
+		bulk.Hashes{"old": {SHA1: "h1"}},
+		[]bulk.Image{{Name: "new", SHA1: "h1", Source: "source"}},
+	},
+	// Name match wins over hash.
+	{
+		[]bulk.Image{{Name: "a", SHA1: "h1"}, {Name: "b", SHA1: "h1"}},
+		[]bulk.Image{{Name: "a", Source: "source a"}, {Name: "old", Source: "source old"}},
+		bulk.Hashes{"old": {SHA1: "h1"}},
+		[]bulk.Image{{Name: "a", SHA1: "h1", Source: "source a"}, {Name: "b", SHA1: "h1", Source: "source old"}},
+	},
+	// Image with its own metadata is not overwritten.
+	{
+		[]bulk.Image{{Name: "a", SHA1: "h1"}},
+		[]bulk.Image{{Name: "a", Source: "source a"}, {Name: "old", Source: "source old"}},
+		bulk.Hashes{"old": {SHA1: "h1"}},
+		[]bulk.Image{{Name: "a", SHA1: "h1", Source: "source a"}},
+	},
+	// Unknown hash.
+	{
+		[]bulk.Image{{Name: "new", SHA1: "h2"}},
+		[]bulk.Image{{Name: "old", Source: "source"}},
+		bulk.Hashes{"old": {SHA1: "h1"}},
+		[]bulk.Image{{Name: "new", SHA1: "h2"}},
+	},
+}
+
+func TestCombineByHash(t *testing.T) {
+	for _, tt := range combineByHashTests {
+		got := bulk.CombineByHash(tt.images, tt.metadata, tt.hashes)
+		if want := tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("CombineByHash(%v, %v, %v) => %v, want %v", tt.images, tt.metadata, tt.hashes, got, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package bulk
+
+import "fmt"
+
+// Strategy is how Import combines the metadata of an imported CSV file with
+// the metadata of the images.
+type Strategy string
+
+// The import strategies.
+const (
+	// StrategyOverwrite replaces the metadata of the images with the
+	// imported metadata.
+	StrategyOverwrite Strategy = "overwrite"
+	// StrategyKeepLocal keeps the metadata of the images and only fills in
+	// empty fields with the imported metadata.
+	StrategyKeepLocal Strategy = "keep"
+	// StrategyUnion keeps the tags of both and reports differing sources and
+	// ratings as conflicts, like Merge.
+	StrategyUnion Strategy = "union"
+	// StrategyThreeWay compares both to the base version they were made
+	// from. Changes made on one side only are kept and changes made on both
+	// sides to the same source or rating are reported as conflicts.
+	StrategyThreeWay Strategy = "threeway"
+)
+
+// Strategies are the import strategies in the order they are offered.
+var Strategies = []Strategy{StrategyOverwrite, StrategyKeepLocal, StrategyUnion, StrategyThreeWay}
+
+// Import returns a copy of local with the metadata of imported combined with
+// strategy. The imported images should be named after the local images, see
+// MatchByHash, and the ones without local image are ignored. base is only used
+// by StrategyThreeWay and may be empty. In conflicts, A is the local value and
+// B the imported one. The combined images keep the local value until the
+// conflicts are resolved with Resolve.
+func Import(local, imported, base []Image, strategy Strategy) ([]Image, []Conflict, error) {
+	imports := make(map[string]Image, len(imported))
+	for _, img := range imported {
+		imports[img.Name] = img
+	}
+	bases := make(map[string]Image, len(base))
+	for _, img := range base {
+		bases[img.Name] = img
+	}
+	var (
+		images    = make([]Image, len(local))
+		conflicts []Conflict
+	)
+	for i, img := range local {
+		images[i] = img
+		in, ok := imports[img.Name]
+		if !ok {
+			continue
+		}
+		var c []Conflict
+		switch strategy {
+		case StrategyOverwrite:
+			images[i].Tags = in.Tags
+			images[i].Source = in.Source
+			images[i].Rating = in.Rating
+			images[i].Extra = in.Extra
+		case StrategyKeepLocal:
+			if len(tagSet(img.Tags)) == 0 {
+				images[i].Tags = in.Tags
+			}
+			for _, f := range []Field{FieldSource, FieldRating} {
+				if FieldValue(img, f) == "" {
+					SetField(&images[i], f, FieldValue(in, f))
+				}
+			}
+		case StrategyUnion:
+			var merged []Image
+			merged, c = Merge([]Image{img}, []Image{in})
+			images[i] = merged[0]
+		case StrategyThreeWay:
+			images[i], c = threeWay(bases[img.Name], img, in)
+		default:
+			return nil, nil, fmt.Errorf("unknown import strategy %q", strategy)
+		}
+		conflicts = append(conflicts, c...)
+	}
+	return images, conflicts, nil
+}
+
+// threeWay merges the metadata of a and b, two versions made from base.
+func threeWay(base, a, b Image) (Image, []Conflict) {
+	img := a
+	// A tag is kept if both have it or if one side added it.
+	var tags []string
+	inBase := tagSet(base.Tags)
+	inA, inB := tagSet(a.Tags), tagSet(b.Tags)
+	for _, t := range UnionTags(a.Tags, b.Tags) {
+		_, okA := inA[t]
+		_, okB := inB[t]
+		_, okBase := inBase[t]
+		if (okA && okB) || !okBase {
+			tags = append(tags, t)
+		}
+	}
+	img.Tags = tags
+
+	var conflicts []Conflict
+	for _, f := range []Field{FieldSource, FieldRating} {
+		vb, va, vi := FieldValue(base, f), FieldValue(a, f), FieldValue(b, f)
+		switch {
+		case va == vi || vi == vb:
+		case va == vb:
+			SetField(&img, f, vi)
+		default:
+			conflicts = append(conflicts, Conflict{Name: a.Name, Field: f, A: va, B: vi})
+		}
+	}
+	return img, conflicts
+}
+
+// tagSet returns the tags without empty tags as a set.
+func tagSet(tags []string) map[string]struct{} {
+	m := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		if t != "" {
+			m[t] = struct{}{}
+		}
+	}
+	return m
+}
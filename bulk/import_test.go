@@ -0,0 +1,96 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var (
+	importBase = []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"a", "b"}, Source: "http://src", Rating: "s"},
+		{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://src", Rating: "s"},
+	}
+	importLocal = []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"a", "b", "local"}, Source: "http://local", Rating: "s"},
+		{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://local", Rating: "s"},
+		{Name: "pic3.jpg", Tags: []string{""}},
+	}
+	importImported = []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"b", "imported"}, Source: "http://src", Rating: "q"},
+		{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://imported", Rating: "s"},
+		{Name: "pic3.jpg", Tags: []string{"c"}, Source: "http://imported", Rating: "e"},
+		{Name: "missing.jpg", Tags: []string{"x"}},
+	}
+)
+
+var importTests = []struct {
+	strategy  bulk.Strategy
+	out       []bulk.Image
+	conflicts []bulk.Conflict
+}{
+	{
+		bulk.StrategyOverwrite,
+		[]bulk.Image{
+			{Name: "pic1.jpg", Tags: []string{"b", "imported"}, Source: "http://src", Rating: "q"},
+			{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://imported", Rating: "s"},
+			{Name: "pic3.jpg", Tags: []string{"c"}, Source: "http://imported", Rating: "e"},
+		},
+		nil,
+	},
+	{
+		bulk.StrategyKeepLocal,
+		[]bulk.Image{
+			{Name: "pic1.jpg", Tags: []string{"a", "b", "local"}, Source: "http://local", Rating: "s"},
+			{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://local", Rating: "s"},
+			{Name: "pic3.jpg", Tags: []string{"c"}, Source: "http://imported", Rating: "e"},
+		},
+		nil,
+	},
+	{
+		bulk.StrategyUnion,
+		[]bulk.Image{
+			{Name: "pic1.jpg", Tags: []string{"a", "b", "local", "imported"}, Source: "http://local", Rating: "s"},
+			{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://local", Rating: "s"},
+			{Name: "pic3.jpg", Tags: []string{"c"}, Source: "http://imported", Rating: "e"},
+		},
+		[]bulk.Conflict{
+			{Name: "pic1.jpg", Field: bulk.FieldSource, A: "http://local", B: "http://src"},
+			{Name: "pic1.jpg", Field: bulk.FieldRating, A: "s", B: "q"},
+			{Name: "pic2.jpg", Field: bulk.FieldSource, A: "http://local", B: "http://imported"},
+		},
+	},
+	{
+		bulk.StrategyThreeWay,
+		[]bulk.Image{
+			// Tag a was removed by the import, local and imported were
+			// added on each side and only the rating changed on import.
+			{Name: "pic1.jpg", Tags: []string{"b", "local", "imported"}, Source: "http://local", Rating: "q"},
+			{Name: "pic2.jpg", Tags: []string{"a"}, Source: "http://local", Rating: "s"},
+			{Name: "pic3.jpg", Tags: []string{"c"}, Source: "http://imported", Rating: "e"},
+		},
+		[]bulk.Conflict{
+			{Name: "pic2.jpg", Field: bulk.FieldSource, A: "http://local", B: "http://imported"},
+		},
+	},
+}
+
+func TestImport(t *testing.T) {
+	for _, tt := range importTests {
+		out, conflicts, err := bulk.Import(importLocal, importImported, importBase, tt.strategy)
+		if err != nil {
+			t.Errorf("Import with %q returned err: %v", tt.strategy, err)
+			continue
+		}
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("Import with %q =>\n%v\nwant\n%v", tt.strategy, out, tt.out)
+		}
+		if !reflect.DeepEqual(conflicts, tt.conflicts) {
+			t.Errorf("Import with %q conflicts => %v, want %v", tt.strategy, conflicts, tt.conflicts)
+		}
+	}
+	if _, _, err := bulk.Import(importLocal, importImported, nil, "unknown"); err == nil {
+		t.Errorf("Import with unknown strategy expected to return err")
+	}
+}
@@ -0,0 +1,89 @@
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Index keeps a stable ID for each image file so that the ID of an image does
+// not change when other files are added or removed. It is kept in a project
+// file next to the CSV file.
+//
+// An ID is never given to another file. A file whose content changed gets a
+// new ID too, so that anything cached by ID, like a preview in the browser,
+// is not reused for the new content.
+type Index struct {
+	// Next is the ID given to the next new file.
+	Next  int                   `json:"next"`
+	Files map[string]IndexEntry `json:"files"`
+}
+
+// IndexEntry is the ID of an image file and the SHA-1 of its content when the
+// ID was given.
+type IndexEntry struct {
+	ID   int    `json:"id"`
+	SHA1 string `json:"sha1"`
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{Files: make(map[string]IndexEntry)}
+}
+
+// LoadIndex reads an index previously written by Index.Save.
+func LoadIndex(r io.Reader) (*Index, error) {
+	idx := NewIndex()
+	if err := json.NewDecoder(r).Decode(idx); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode index: %v", err)
+	}
+	if idx.Files == nil {
+		idx.Files = make(map[string]IndexEntry)
+	}
+	return idx, nil
+}
+
+// Save writes the index to an open for writing file.
+func (idx *Index) Save(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(idx); err != nil {
+		return fmt.Errorf("encode index: %v", err)
+	}
+	return nil
+}
+
+// Assign sets the ID of each image from the index, giving new IDs, in the
+// order of images, to the files that are not in the index or whose content
+// changed. The SHA1 of the images should be set by HashImages first. Files
+// that no longer exist are removed from the index. It reports whether the
+// index changed.
+func (idx *Index) Assign(images []Image) bool {
+	changed := false
+	for _, e := range idx.Files {
+		if e.ID >= idx.Next {
+			idx.Next = e.ID + 1
+			changed = true
+		}
+	}
+	found := make(map[string]struct{}, len(images))
+	for i := range images {
+		name := images[i].Name
+		found[name] = struct{}{}
+		e, ok := idx.Files[name]
+		if !ok || e.SHA1 != images[i].SHA1 {
+			e = IndexEntry{ID: idx.Next, SHA1: images[i].SHA1}
+			idx.Files[name] = e
+			idx.Next++
+			changed = true
+		}
+		images[i].ID = e.ID
+	}
+	for name := range idx.Files {
+		if _, ok := found[name]; !ok {
+			delete(idx.Files, name)
+			changed = true
+		}
+	}
+	return changed
+}
@@ -0,0 +1,87 @@
+package bulk_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func ids(images []bulk.Image) map[string]int {
+	m := make(map[string]int, len(images))
+	for _, img := range images {
+		m[img.Name] = img.ID
+	}
+	return m
+}
+
+func TestIndex_Assign(t *testing.T) {
+	idx := bulk.NewIndex()
+	images := []bulk.Image{
+		{Name: "a.png", SHA1: "1"},
+		{Name: "c.png", SHA1: "3"},
+	}
+	if !idx.Assign(images) {
+		t.Errorf("Assign on empty index reported no change")
+	}
+	if got := ids(images); got["a.png"] != 0 || got["c.png"] != 1 {
+		t.Errorf("first Assign => %v, want a.png: 0, c.png: 1", got)
+	}
+	if idx.Assign(images) {
+		t.Errorf("Assign of the same images reported a change")
+	}
+
+	// A file added in the middle does not shift the others.
+	images = []bulk.Image{
+		{Name: "a.png", SHA1: "1"},
+		{Name: "b.png", SHA1: "2"},
+		{Name: "c.png", SHA1: "3"},
+	}
+	idx.Assign(images)
+	if got := ids(images); got["a.png"] != 0 || got["b.png"] != 2 || got["c.png"] != 1 {
+		t.Errorf("Assign with added file => %v, want a.png: 0, b.png: 2, c.png: 1", got)
+	}
+
+	// IDs of removed files are not given again and changed files get a new
+	// ID.
+	images = []bulk.Image{
+		{Name: "b.png", SHA1: "2"},
+		{Name: "c.png", SHA1: "changed"},
+		{Name: "d.png", SHA1: "4"},
+	}
+	idx.Assign(images)
+	if got := ids(images); got["b.png"] != 2 || got["c.png"] != 3 || got["d.png"] != 4 {
+		t.Errorf("Assign with removed and changed files => %v, want b.png: 2, c.png: 3, d.png: 4", got)
+	}
+	if _, ok := idx.Files["a.png"]; ok {
+		t.Errorf("Assign kept removed file a.png in the index")
+	}
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	idx := bulk.NewIndex()
+	images := []bulk.Image{{Name: "a.png", SHA1: "1"}, {Name: "b.png", SHA1: "2"}}
+	idx.Assign(images)
+
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := bulk.LoadIndex(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := []bulk.Image{{Name: "b.png", SHA1: "2"}, {Name: "new.png", SHA1: "3"}, {Name: "a.png", SHA1: "1"}}
+	loaded.Assign(again)
+	if got := ids(again); got["a.png"] != 0 || got["b.png"] != 1 || got["new.png"] != 2 {
+		t.Errorf("Assign after LoadIndex => %v, want a.png: 0, b.png: 1, new.png: 2", got)
+	}
+
+	empty, err := bulk.LoadIndex(&bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("LoadIndex of empty file returned err: %v", err)
+	}
+	if empty.Files == nil {
+		t.Errorf("LoadIndex of empty file returned nil Files")
+	}
+}
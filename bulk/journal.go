@@ -0,0 +1,275 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Op is the kind of a journal record.
+type Op string
+
+// The journal operations.
+const (
+	// OpEdit records changes made to the metadata.
+	OpEdit Op = "edit"
+	// OpUndo records that the changes of an edit were reverted.
+	OpUndo Op = "undo"
+	// OpRedo records that the changes of an undone edit were made again.
+	OpRedo Op = "redo"
+	// OpRename records that the file of an image was renamed. The earlier
+	// changes of the image are then found under its new name.
+	OpRename Op = "rename"
+)
+
+// FieldChange is a change of a metadata field of an image. Values are text as
+// returned by FieldValue.
+type FieldChange struct {
+	Image string `json:"image"`
+	Field Field  `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// Record is an entry of the journal.
+type Record struct {
+	Time time.Time `json:"time"`
+	Op   Op        `json:"op"`
+	// Edit identifies an edit. Undo and redo records use the identifier of
+	// the edit they undo or redo.
+	Edit int `json:"edit"`
+	// Changes are the changes of an edit. Undo and redo records have none.
+	Changes []FieldChange `json:"changes,omitempty"`
+	// From and To are the old and new names of a renamed image.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// Journal is an append-only log of the changes made to the metadata of the
+// images. It is stored with one JSON record per line.
+type Journal struct {
+	Records []Record
+}
+
+// ReadJournal reads the records of a journal. A partial last record, as left
+// by a crash while appending, is ignored.
+func ReadJournal(r io.Reader) (*Journal, error) {
+	j := &Journal{}
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		b, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		last := err == io.EOF
+		if b = bytes.TrimSpace(b); len(b) != 0 {
+			var rec Record
+			if jerr := json.Unmarshal(b, &rec); jerr != nil {
+				if last {
+					break
+				}
+				return nil, fmt.Errorf("journal line %d: %v", line, jerr)
+			}
+			j.Records = append(j.Records, rec)
+			if rec.Op == OpRename {
+				j.rename(rec.From, rec.To)
+			}
+		}
+		if last {
+			break
+		}
+	}
+	return j, nil
+}
+
+// Add appends rec to the journal and writes it to w which should be the
+// journal file open for appending. Edit records get a new edit identifier and
+// records without time get the current time.
+func (j *Journal) Add(w io.Writer, rec Record) (Record, error) {
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	if rec.Op == OpEdit {
+		rec.Edit = 1
+		for _, r := range j.Records {
+			if r.Op == OpEdit && r.Edit >= rec.Edit {
+				rec.Edit = r.Edit + 1
+			}
+		}
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return rec, err
+	}
+	if _, err := w.Write(append(b, '\n')); err != nil {
+		return rec, fmt.Errorf("write journal: %v", err)
+	}
+	j.Records = append(j.Records, rec)
+	if rec.Op == OpRename {
+		j.rename(rec.From, rec.To)
+	}
+	return rec, nil
+}
+
+// rename moves the changes of the image named from to the image named to so
+// that the history, undo and redo follow the image. Only the records in memory
+// are changed.
+func (j *Journal) rename(from, to string) {
+	for i, r := range j.Records {
+		var changes []FieldChange
+		for k, c := range r.Changes {
+			if c.Image != from {
+				continue
+			}
+			if changes == nil {
+				changes = append([]FieldChange(nil), r.Changes...)
+			}
+			changes[k].Image = to
+		}
+		if changes != nil {
+			j.Records[i].Changes = changes
+		}
+	}
+}
+
+// edit returns the edit record with identifier id.
+func (j *Journal) edit(id int) (Record, bool) {
+	for _, r := range j.Records {
+		if r.Op == OpEdit && r.Edit == id {
+			return r, true
+		}
+	}
+	return Record{}, false
+}
+
+// stacks replays the journal and returns the identifiers of the edits that
+// can be undone and redone, the next one last.
+func (j *Journal) stacks() (undo, redo []int) {
+	for _, r := range j.Records {
+		switch r.Op {
+		case OpEdit:
+			undo = append(undo, r.Edit)
+			redo = nil
+		case OpUndo:
+			if n := len(undo); n != 0 && undo[n-1] == r.Edit {
+				undo = undo[:n-1]
+				redo = append(redo, r.Edit)
+			}
+		case OpRedo:
+			if n := len(redo); n != 0 && redo[n-1] == r.Edit {
+				redo = redo[:n-1]
+				undo = append(undo, r.Edit)
+			}
+		}
+	}
+	return undo, redo
+}
+
+// Undo returns the edit that would be undone next. Its changes should be
+// applied in reverse and then an OpUndo record added.
+func (j *Journal) Undo() (Record, bool) {
+	undo, _ := j.stacks()
+	if len(undo) == 0 {
+		return Record{}, false
+	}
+	return j.edit(undo[len(undo)-1])
+}
+
+// Redo returns the edit that would be redone next. Its changes should be
+// applied and then an OpRedo record added.
+func (j *Journal) Redo() (Record, bool) {
+	_, redo := j.stacks()
+	if len(redo) == 0 {
+		return Record{}, false
+	}
+	return j.edit(redo[len(redo)-1])
+}
+
+// HistoryEntry is a change of a field of an image as shown in its history.
+type HistoryEntry struct {
+	Time time.Time
+	Op   Op
+	Edit int
+	FieldChange
+}
+
+// History returns the changes made to the fields of the named image, newest
+// first. Undone changes appear reversed.
+func (j *Journal) History(image string) []HistoryEntry {
+	var h []HistoryEntry
+	for _, r := range j.Records {
+		if r.Op == OpRename {
+			continue
+		}
+		changes := r.Changes
+		if r.Op != OpEdit {
+			e, ok := j.edit(r.Edit)
+			if !ok {
+				continue
+			}
+			changes = e.Changes
+			if r.Op == OpUndo {
+				changes = ReverseChanges(changes)
+			}
+		}
+		for _, c := range changes {
+			if c.Image == image {
+				h = append(h, HistoryEntry{Time: r.Time, Op: r.Op, Edit: r.Edit, FieldChange: c})
+			}
+		}
+	}
+	for i, k := 0, len(h)-1; i < k; i, k = i+1, k-1 {
+		h[i], h[k] = h[k], h[i]
+	}
+	return h
+}
+
+// FieldChanges returns the changes of the metadata fields from old to new.
+// Images are matched by Name. Images only found in new are compared to an
+// image without metadata and images only found in old are left out.
+func FieldChanges(old, new []Image) []FieldChange {
+	olds := make(map[string]Image, len(old))
+	for _, img := range old {
+		olds[img.Name] = img
+	}
+	var changes []FieldChange
+	for _, img := range new {
+		o := olds[img.Name]
+		for _, f := range ChangedFields(o, img) {
+			changes = append(changes, FieldChange{
+				Image: img.Name,
+				Field: f,
+				Old:   FieldValue(o, f),
+				New:   FieldValue(img, f),
+			})
+		}
+	}
+	return changes
+}
+
+// ReverseChanges returns the changes that revert changes.
+func ReverseChanges(changes []FieldChange) []FieldChange {
+	reversed := make([]FieldChange, len(changes))
+	for i, c := range changes {
+		c.Old, c.New = c.New, c.Old
+		reversed[len(changes)-1-i] = c
+	}
+	return reversed
+}
+
+// ApplyChanges sets the fields of images to the new values of changes.
+// Changes of images that are not found are skipped.
+func ApplyChanges(images []Image, changes []FieldChange) {
+	byName := make(map[string]int, len(images))
+	for i, img := range images {
+		byName[img.Name] = i
+	}
+	for _, c := range changes {
+		if i, ok := byName[c.Image]; ok {
+			SetField(&images[i], c.Field, c.New)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package bulk_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestFieldChanges(t *testing.T) {
+	old := []bulk.Image{
+		{Name: "a", Tags: []string{"t1"}, Rating: "s"},
+		{Name: "removed", Tags: []string{"t1"}},
+	}
+	new := []bulk.Image{
+		{Name: "a", Tags: []string{"t1", "t2"}, Rating: "q"},
+		{Name: "added", Source: "src"},
+	}
+	got := bulk.FieldChanges(old, new)
+	want := []bulk.FieldChange{
+		{Image: "a", Field: bulk.FieldTags, Old: "t1", New: "t1 t2"},
+		{Image: "a", Field: bulk.FieldRating, Old: "s", New: "q"},
+		{Image: "added", Field: bulk.FieldSource, Old: "", New: "src"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FieldChanges() => %+v, want %+v", got, want)
+	}
+}
+
+func TestApplyChanges(t *testing.T) {
+	images := []bulk.Image{
+		{Name: "a", Tags: []string{"t1"}, Rating: "s"},
+	}
+	changes := []bulk.FieldChange{
+		{Image: "a", Field: bulk.FieldTags, Old: "t1", New: "t1 t2"},
+		{Image: "a", Field: bulk.FieldRating, Old: "s", New: "q"},
+		{Image: "missing", Field: bulk.FieldSource, Old: "", New: "src"},
+	}
+	bulk.ApplyChanges(images, changes)
+	want := []bulk.Image{{Name: "a", Tags: []string{"t1", "t2"}, Rating: "q"}}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("ApplyChanges() => %+v, want %+v", images, want)
+	}
+	bulk.ApplyChanges(images, bulk.ReverseChanges(changes))
+	want = []bulk.Image{{Name: "a", Tags: []string{"t1"}, Rating: "s"}}
+	if !reflect.DeepEqual(images, want) {
+		t.Errorf("ApplyChanges(ReverseChanges()) => %+v, want %+v", images, want)
+	}
+}
+
+func TestJournal_undoRedo(t *testing.T) {
+	var buf bytes.Buffer
+	j := &bulk.Journal{}
+	edit := func(image, old, new string) {
+		t.Helper()
+		changes := []bulk.FieldChange{{Image: image, Field: bulk.FieldTags, Old: old, New: new}}
+		if _, err := j.Add(&buf, bulk.Record{Op: bulk.OpEdit, Changes: changes}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add := func(op bulk.Op, id int) {
+		t.Helper()
+		if _, err := j.Add(&buf, bulk.Record{Op: op, Edit: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func(want int, rec bulk.Record, ok bool) {
+		t.Helper()
+		if want == 0 && ok {
+			t.Errorf("expected nothing, got edit %d", rec.Edit)
+		}
+		if want != 0 && (!ok || rec.Edit != want) {
+			t.Errorf("expected edit %d, got %d (%v)", want, rec.Edit, ok)
+		}
+	}
+
+	next(0, bulk.Record{}, false)
+	edit("a", "", "t1")
+	edit("a", "t1", "t2")
+	rec, ok := j.Undo()
+	next(2, rec, ok)
+	add(bulk.OpUndo, 2)
+	rec, ok = j.Undo()
+	next(1, rec, ok)
+	rec, ok = j.Redo()
+	next(2, rec, ok)
+	add(bulk.OpRedo, 2)
+	rec, ok = j.Redo()
+	next(0, rec, ok)
+	add(bulk.OpUndo, 2)
+	// A new edit after an undo clears what can be redone.
+	edit("a", "t1", "t3")
+	rec, ok = j.Redo()
+	next(0, rec, ok)
+	rec, ok = j.Undo()
+	next(3, rec, ok)
+
+	// The journal read back has the same records and edit identifiers.
+	read, err := bulk.ReadJournal(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read.Records) != len(j.Records) {
+		t.Fatalf("ReadJournal read %d records, want %d", len(read.Records), len(j.Records))
+	}
+	rec, ok = read.Undo()
+	next(3, rec, ok)
+
+	var got []string
+	for _, e := range read.History("a") {
+		got = append(got, string(e.Op)+" "+e.Old+">"+e.New)
+	}
+	want := []string{"edit t1>t3", "undo t2>t1", "redo t1>t2", "undo t2>t1", "edit t1>t2", "edit >t1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("History(a) => %q, want %q", got, want)
+	}
+	if h := read.History("b"); len(h) != 0 {
+		t.Errorf("History(b) => %+v, want empty", h)
+	}
+}
+
+func TestReadJournal_partialLastRecord(t *testing.T) {
+	in := `{"time":"2018-01-01T00:00:00Z","op":"edit","edit":1}
+{"time":"2018-01-01T00:00:00Z","op":"ed`
+	j, err := bulk.ReadJournal(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("ReadJournal returned err: %v", err)
+	}
+	want := []bulk.Record{{Time: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), Op: bulk.OpEdit, Edit: 1}}
+	if !reflect.DeepEqual(j.Records, want) {
+		t.Errorf("ReadJournal => %+v, want %+v", j.Records, want)
+	}
+}
+
+func TestReadJournal_corrupt(t *testing.T) {
+	in := "{\"op\":\n{\"op\":\"edit\",\"edit\":1}\n"
+	if _, err := bulk.ReadJournal(strings.NewReader(in)); err == nil {
+		t.Errorf("ReadJournal(%q) expected to return err", in)
+	}
+}
+
+func TestJournal_rename(t *testing.T) {
+	var buf bytes.Buffer
+	j := &bulk.Journal{}
+	changes := []bulk.FieldChange{{Image: "a.jpg", Field: bulk.FieldTags, Old: "", New: "t1"}}
+	for _, rec := range []bulk.Record{
+		{Op: bulk.OpEdit, Changes: changes},
+		{Op: bulk.OpRename, From: "a.jpg", To: "a.png"},
+	} {
+		if _, err := j.Add(&buf, rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read, err := bulk.ReadJournal(&buf)
+	if err != nil {
+		t.Fatalf("ReadJournal returned err: %v", err)
+	}
+	want := []bulk.FieldChange{{Image: "a.png", Field: bulk.FieldTags, Old: "", New: "t1"}}
+	for _, j := range []*bulk.Journal{j, read} {
+		if h := j.History("a.png"); len(h) != 1 || h[0].FieldChange != want[0] {
+			t.Errorf("History(a.png) => %+v, want %+v", h, want)
+		}
+		if h := j.History("a.jpg"); len(h) != 0 {
+			t.Errorf("History(a.jpg) => %+v, want empty", h)
+		}
+		if rec, ok := j.Undo(); !ok || !reflect.DeepEqual(rec.Changes, want) {
+			t.Errorf("Undo() => %+v, %v, want changes %+v", rec.Changes, ok, want)
+		}
+	}
+	if changes[0].Image != "a.jpg" {
+		t.Errorf("rename changed the added changes to %+v", changes)
+	}
+}
@@ -0,0 +1,178 @@
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Preview describes how the web interface previews a media type.
+type Preview int
+
+const (
+	// PreviewImage media are served as they are and shown with an img
+	// element.
+	PreviewImage Preview = iota
+	// PreviewVideo media are served as they are and shown with a video
+	// element.
+	PreviewVideo
+	// PreviewPlaceholder media cannot be shown by a browser so a placeholder
+	// image is served in their place.
+	PreviewPlaceholder
+)
+
+func (p Preview) String() string {
+	switch p {
+	case PreviewImage:
+		return "image"
+	case PreviewVideo:
+		return "video"
+	case PreviewPlaceholder:
+		return "placeholder"
+	default:
+		return "unknown"
+	}
+}
+
+// MarshalText encodes the preview as its name.
+func (p Preview) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText decodes a preview from its name.
+func (p *Preview) UnmarshalText(b []byte) error {
+	switch strings.ToLower(string(b)) {
+	case "image":
+		*p = PreviewImage
+	case "video":
+		*p = PreviewVideo
+	case "placeholder":
+		*p = PreviewPlaceholder
+	default:
+		return fmt.Errorf("unknown preview %q", b)
+	}
+	return nil
+}
+
+// MediaType describes a kind of file that can be tagged.
+type MediaType struct {
+	// Name is a short unique name for the type like "jpeg".
+	Name string `json:"name"`
+	// Extensions are the file extensions, without the dot, that identify
+	// the type. They are matched case insensitively.
+	Extensions []string `json:"extensions"`
+	// MIME is the content type used when serving the file.
+	MIME string `json:"mime"`
+	// Preview is how the file is previewed in the web interface.
+	Preview Preview `json:"preview"`
+	// Uploadable reports whether the file can be uploaded to the server.
+	Uploadable bool `json:"uploadable"`
+}
+
+// DefaultMediaTypes are the media types known to Shimmie2.
+var DefaultMediaTypes = []MediaType{
+	{Name: "gif", Extensions: []string{"gif"}, MIME: "image/gif", Preview: PreviewImage, Uploadable: true},
+	{Name: "jpeg", Extensions: []string{"jpeg", "jpg"}, MIME: "image/jpeg", Preview: PreviewImage, Uploadable: true},
+	{Name: "png", Extensions: []string{"png"}, MIME: "image/png", Preview: PreviewImage, Uploadable: true},
+	{Name: "swf", Extensions: []string{"swf"}, MIME: "application/x-shockwave-flash", Preview: PreviewPlaceholder, Uploadable: true},
+	{Name: "webm", Extensions: []string{"webm"}, MIME: "video/webm", Preview: PreviewVideo, Uploadable: true},
+	{Name: "mp4", Extensions: []string{"mp4"}, MIME: "video/mp4", Preview: PreviewVideo, Uploadable: true},
+	{Name: "webp", Extensions: []string{"webp"}, MIME: "image/webp", Preview: PreviewImage, Uploadable: true},
+	{Name: "avif", Extensions: []string{"avif"}, MIME: "image/avif", Preview: PreviewImage, Uploadable: true},
+}
+
+// Registry holds the media types that are recognized when loading images.
+type Registry struct {
+	types []MediaType
+	byExt map[string]int
+}
+
+// NewRegistry returns a registry with the provided media types.
+func NewRegistry(types ...MediaType) *Registry {
+	r := &Registry{byExt: make(map[string]int)}
+	for _, t := range types {
+		r.Register(t)
+	}
+	return r
+}
+
+// Register adds a media type to the registry. A type with the same name
+// is replaced and extensions already claimed by another type are taken over.
+func (r *Registry) Register(t MediaType) {
+	i := -1
+	for j := range r.types {
+		if r.types[j].Name == t.Name {
+			i = j
+			break
+		}
+	}
+	if i == -1 {
+		r.types = append(r.types, t)
+		i = len(r.types) - 1
+	} else {
+		for ext, j := range r.byExt {
+			if j == i {
+				delete(r.byExt, ext)
+			}
+		}
+		r.types[i] = t
+	}
+	for _, ext := range t.Extensions {
+		r.byExt[strings.ToLower(ext)] = i
+	}
+}
+
+// Lookup returns the media type of a file based on its extension.
+func (r *Registry) Lookup(name string) (MediaType, bool) {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+	i, ok := r.byExt[ext]
+	if !ok {
+		return MediaType{}, false
+	}
+	return r.types[i], true
+}
+
+// Types returns the registered media types in the order they were
+// registered.
+func (r *Registry) Types() []MediaType {
+	types := make([]MediaType, len(r.types))
+	copy(types, r.types)
+	return types
+}
+
+// Extensions returns the extensions of all the registered media types.
+func (r *Registry) Extensions() []string {
+	var exts []string
+	for _, t := range r.types {
+		for _, ext := range t.Extensions {
+			if i, ok := r.byExt[strings.ToLower(ext)]; ok && r.types[i].Name == t.Name {
+				exts = append(exts, ext)
+			}
+		}
+	}
+	return exts
+}
+
+// LoadRegistry reads a JSON array of media types and returns a registry
+// holding only those types.
+func LoadRegistry(file io.Reader) (*Registry, error) {
+	var types []MediaType
+	if err := json.NewDecoder(file).Decode(&types); err != nil {
+		return nil, fmt.Errorf("decode media types: %v", err)
+	}
+	for _, t := range types {
+		if t.Name == "" {
+			return nil, fmt.Errorf("media type without name")
+		}
+		if len(t.Extensions) == 0 {
+			return nil, fmt.Errorf("media type %q has no extensions", t.Name)
+		}
+	}
+	return NewRegistry(types...), nil
+}
+
+// MediaTypes is the registry used by LoadImages and WalkImages. It can be
+// replaced on start up to support a different set of media types.
+var MediaTypes = NewRegistry(DefaultMediaTypes...)
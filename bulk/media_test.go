@@ -0,0 +1,73 @@
+package bulk_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var registryLookupTests = []struct {
+	in   string
+	name string
+	ok   bool
+}{
+	{"a.jpg", "jpeg", true},
+	{"a.JPEG", "jpeg", true},
+	{"dir/a.webm", "webm", true},
+	{"a.ico", "", false},
+	{"jpg", "", false},
+	{"", "", false},
+}
+
+func TestRegistryLookup(t *testing.T) {
+	r := bulk.NewRegistry(bulk.DefaultMediaTypes...)
+	for _, tt := range registryLookupTests {
+		got, ok := r.Lookup(tt.in)
+		if ok != tt.ok || got.Name != tt.name {
+			t.Errorf("Lookup(%q) => %q, %v, want %q, %v", tt.in, got.Name, ok, tt.name, tt.ok)
+		}
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	r := bulk.NewRegistry(bulk.DefaultMediaTypes[:2]...)
+	r.Register(bulk.MediaType{Name: "jpeg", Extensions: []string{"jpe"}, MIME: "image/jpeg"})
+	r.Register(bulk.MediaType{Name: "gif2", Extensions: []string{"gif"}, MIME: "image/gif"})
+
+	if got, want := r.Extensions(), []string{"jpe", "gif"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Extensions() => %v, want %v", got, want)
+	}
+	if _, ok := r.Lookup("a.jpg"); ok {
+		t.Errorf("Lookup(%q) found a type after its extension was replaced", "a.jpg")
+	}
+	if got, _ := r.Lookup("a.gif"); got.Name != "gif2" {
+		t.Errorf("Lookup(%q) => %q, want %q", "a.gif", got.Name, "gif2")
+	}
+}
+
+func TestLoadRegistry(t *testing.T) {
+	in := `[{"name": "webm", "extensions": ["webm"], "mime": "video/webm", "preview": "video", "uploadable": true}]`
+	r, err := bulk.LoadRegistry(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("LoadRegistry(%q) returned err %v", in, err)
+	}
+	want := []bulk.MediaType{
+		{Name: "webm", Extensions: []string{"webm"}, MIME: "video/webm", Preview: bulk.PreviewVideo, Uploadable: true},
+	}
+	if got := r.Types(); !reflect.DeepEqual(got, want) {
+		t.Errorf("LoadRegistry(%q) => %v, want %v", in, got, want)
+	}
+
+	for _, in := range []string{
+		`[{"name": "webm", "extensions": ["webm"], "preview": "flash"}]`,
+		`[{"name": "webm"}]`,
+		`[{"extensions": ["webm"]}]`,
+		`{`,
+	} {
+		if _, err := bulk.LoadRegistry(strings.NewReader(in)); err == nil {
+			t.Errorf("LoadRegistry(%q) expected to return err", in)
+		}
+	}
+}
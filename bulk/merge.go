@@ -0,0 +1,69 @@
+package bulk
+
+import "sort"
+
+// Conflict is a field of an image that has a different value in each of the
+// merged versions.
+type Conflict struct {
+	Name  string
+	Field Field
+	A, B  string
+}
+
+// Merge combines two versions a and b of the image metadata, for example the
+// copies of the CSV file of two people that tagged the same batch. Images are
+// matched by Name and images found in only one version are kept as they are.
+//
+// The tags of the images found in both versions are the union of their tags.
+// A source or rating that is empty in one version is taken from the other.
+// Sources and ratings that differ otherwise are returned as conflicts and the
+// merged image keeps the value of a until the conflict is resolved with
+// Resolve. The merged images are sorted by name.
+func Merge(a, b []Image) ([]Image, []Conflict) {
+	bs := make(map[string]Image, len(b))
+	for _, img := range b {
+		bs[img.Name] = img
+	}
+	var (
+		merged    []Image
+		conflicts []Conflict
+		seen      = make(map[string]struct{}, len(a))
+	)
+	for _, img := range a {
+		seen[img.Name] = struct{}{}
+		other, ok := bs[img.Name]
+		if !ok {
+			merged = append(merged, img)
+			continue
+		}
+		img.Tags = UnionTags(img.Tags, other.Tags)
+		for _, f := range []Field{FieldSource, FieldRating} {
+			va, vb := FieldValue(img, f), FieldValue(other, f)
+			switch {
+			case va == vb || vb == "":
+			case va == "":
+				SetField(&img, f, vb)
+			default:
+				conflicts = append(conflicts, Conflict{Name: img.Name, Field: f, A: va, B: vb})
+			}
+		}
+		merged = append(merged, img)
+	}
+	for _, img := range b {
+		if _, ok := seen[img.Name]; !ok {
+			merged = append(merged, img)
+		}
+	}
+	sort.Sort(byName(merged))
+	sort.SliceStable(conflicts, func(i, j int) bool { return conflicts[i].Name < conflicts[j].Name })
+	return merged, conflicts
+}
+
+// Resolve sets the field of the conflict c to value in the image of images
+// that has the conflict. Like the other functions that find images by name,
+// it sorts images by name.
+func Resolve(images []Image, c Conflict, value string) {
+	if img := findByName(images, c.Name); img != nil {
+		SetField(img, c.Field, value)
+	}
+}
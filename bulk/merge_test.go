@@ -0,0 +1,41 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestMerge(t *testing.T) {
+	a := []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"a", "b"}, Source: "http://src", Rating: "s"},
+		{Name: "pic2.jpg", Tags: []string{"a"}, Source: "", Rating: "q"},
+		{Name: "pic3.jpg", Tags: []string{"only_a"}},
+	}
+	b := []bulk.Image{
+		{Name: "pic4.jpg", Tags: []string{"only_b"}},
+		{Name: "pic2.jpg", Tags: []string{"b", "a", ""}, Source: "http://other", Rating: "e"},
+		{Name: "pic1.jpg", Tags: []string{"c"}, Source: "http://src", Rating: ""},
+	}
+	merged, conflicts := bulk.Merge(a, b)
+
+	want := []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"a", "b", "c"}, Source: "http://src", Rating: "s"},
+		{Name: "pic2.jpg", Tags: []string{"a", "b"}, Source: "http://other", Rating: "q"},
+		{Name: "pic3.jpg", Tags: []string{"only_a"}},
+		{Name: "pic4.jpg", Tags: []string{"only_b"}},
+	}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("Merge =>\n%v\nwant\n%v", merged, want)
+	}
+	wantConflicts := []bulk.Conflict{{Name: "pic2.jpg", Field: bulk.FieldRating, A: "q", B: "e"}}
+	if !reflect.DeepEqual(conflicts, wantConflicts) {
+		t.Errorf("Merge conflicts => %v, want %v", conflicts, wantConflicts)
+	}
+
+	bulk.Resolve(merged, conflicts[0], conflicts[0].B)
+	if got := merged[1].Rating; got != "e" {
+		t.Errorf("Resolve set rating %q, want %q", got, "e")
+	}
+}
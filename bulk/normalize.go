@@ -0,0 +1,157 @@
+package bulk
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Normalizer cleans up tags before they are saved. Each step of the pipeline
+// can be switched on or off and the steps run in the order of the fields. The
+// zero value has every step switched off and leaves tags as they are.
+type Normalizer struct {
+	// Spaces turns full-width and other non-ASCII spaces inside a tag into
+	// Separator, collapses repeated separators and trims them from both ends
+	// of the tag.
+	Spaces bool `json:"spaces"`
+	// Separator is the board's convention for spaces inside a tag.
+	Separator string `json:"separator"`
+	// TrimPunctuation trims stray punctuation, usually left over from
+	// pasting comma separated lists, from both ends of a tag.
+	TrimPunctuation bool `json:"trimPunctuation"`
+	// Lowercase turns tags to lower case.
+	Lowercase bool `json:"lowercase"`
+	// Prefixes collapses different spellings of the prefix of one of the
+	// TagCategories, like "Artist:" or "artist :", to the prefix itself.
+	Prefixes bool `json:"prefixes"`
+	// DropEmpty drops tags that are empty.
+	DropEmpty bool `json:"dropEmpty"`
+}
+
+// DefaultNormalizer is the normalizer of projects that do not configure one.
+// Every step is switched off so tags are saved as they are typed. The
+// underscore is the separator used once Spaces is switched on.
+var DefaultNormalizer = Normalizer{Separator: "_"}
+
+// FullNormalizer has every step switched on and uses the underscore as
+// separator.
+var FullNormalizer = Normalizer{
+	Spaces:          true,
+	Separator:       "_",
+	TrimPunctuation: true,
+	Lowercase:       true,
+	Prefixes:        true,
+	DropEmpty:       true,
+}
+
+// punctuation is trimmed from the ends of tags by the TrimPunctuation step.
+const punctuation = ",;.\"'`、。，；"
+
+// Tag returns the normalized form of a single tag.
+func (n Normalizer) Tag(tag string) string {
+	if n.Spaces {
+		tag = n.spaces(tag)
+	}
+	if n.TrimPunctuation {
+		if t := strings.Trim(tag, punctuation); t != "" {
+			tag = t
+		}
+	}
+	if n.Lowercase {
+		tag = strings.ToLower(tag)
+	}
+	if n.Prefixes {
+		tag = n.prefix(tag)
+	}
+	return tag
+}
+
+func (n Normalizer) spaces(tag string) string {
+	sep := n.Separator
+	tag = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		return r
+	}, tag)
+	if sep == "" {
+		return strings.Join(strings.Fields(tag), "")
+	}
+	parts := strings.FieldsFunc(tag, func(r rune) bool { return r == ' ' })
+	tag = strings.Join(parts, sep)
+	for strings.Contains(tag, sep+sep) {
+		tag = strings.Replace(tag, sep+sep, sep, -1)
+	}
+	return strings.Trim(tag, sep)
+}
+
+// prefix collapses the spelling of a category prefix. The part before
+// the first colon is compared case insensitively and ignoring spaces and
+// separators around it.
+func (n Normalizer) prefix(tag string) string {
+	i := strings.Index(tag, ":")
+	if i == -1 {
+		return tag
+	}
+	cut := " _"
+	if n.Separator != "" {
+		cut += n.Separator
+	}
+	p := strings.ToLower(strings.Trim(tag[:i], cut)) + ":"
+	for _, known := range TagCategories.Prefixes() {
+		if p == known {
+			return known + strings.TrimLeft(tag[i+1:], cut)
+		}
+	}
+	return tag
+}
+
+// Tags returns the normalized form of tags.
+func (n Normalizer) Tags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = n.Tag(t)
+		if n.DropEmpty && t == "" {
+			continue
+		}
+		out = append(out, t)
+	}
+	return out
+}
+
+// Images returns a copy of images with their tags normalized.
+func (n Normalizer) Images(images []Image) []Image {
+	out := make([]Image, len(images))
+	for i, img := range images {
+		img.Tags = n.Tags(img.Tags)
+		out[i] = img
+	}
+	return out
+}
+
+// SplitTags splits the text of a tags input into tags at white space, like
+// strings.Fields, and at commas since the CSV file cannot have tags with
+// commas.
+func SplitTags(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == ','
+	})
+}
+
+// SplitTags is like the package SplitTags but, when the Spaces step is
+// switched on, only ASCII white space separates tags so that full-width spaces
+// stay inside a tag where the step turns them into the board's separator.
+func (n Normalizer) SplitTags(s string) []string {
+	if !n.Spaces {
+		return SplitTags(s)
+	}
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == '\r' || r == '\v' || r == '\f' || r == ','
+	})
+}
+
+// NormalizeTags normalizes tags with the FullNormalizer. It is meant for
+// scripts that need to clean up tags the way tagaa does when every step is
+// switched on.
+func NormalizeTags(tags []string) []string {
+	return FullNormalizer.Tags(tags)
+}
@@ -0,0 +1,88 @@
+package bulk_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var normalizeTagTests = []struct {
+	in  string
+	out string
+}{
+	{"", ""},
+	{"tag", "tag"},
+	{"Long_Hair", "long_hair"},
+	{"long　hair", "long_hair"},
+	{"_long__hair_", "long_hair"},
+	{"long_ hair", "long_hair"},
+	{"tag,", "tag"},
+	{"\"tag\";", "tag"},
+	{"tag。", "tag"},
+	{"...", "..."},
+	{"^_^", "^_^"},
+	{"Artist:someone", "artist:someone"},
+	{"ARTIST : someone", "artist:someone"},
+	{"artist_:someone", "artist:someone"},
+	{"Series:kantai_collection", "series:kantai_collection"},
+	{"unknown:tag", "unknown:tag"},
+	{"character:shimakaze_(kantai_collection)", "character:shimakaze_(kantai_collection)"},
+}
+
+func TestNormalizer_Tag(t *testing.T) {
+	for _, tt := range normalizeTagTests {
+		if got, want := bulk.FullNormalizer.Tag(tt.in), tt.out; got != want {
+			t.Errorf("Tag(%q) => %q, want %q", tt.in, got, want)
+		}
+	}
+}
+
+func TestNormalizer_steps(t *testing.T) {
+	tags := []string{"Artist:Someone", "long　hair,", "", "__"}
+	tests := []struct {
+		n   bulk.Normalizer
+		out []string
+	}{
+		{bulk.Normalizer{}, []string{"Artist:Someone", "long　hair,", "", "__"}},
+		{bulk.Normalizer{DropEmpty: true}, []string{"Artist:Someone", "long　hair,", "__"}},
+		{bulk.Normalizer{Spaces: true, Separator: "_"}, []string{"Artist:Someone", "long_hair,", "", ""}},
+		{bulk.Normalizer{Spaces: true, Separator: "-"}, []string{"Artist:Someone", "long-hair,", "", "__"}},
+		{bulk.Normalizer{Lowercase: true}, []string{"artist:someone", "long　hair,", "", "__"}},
+		{bulk.Normalizer{Prefixes: true}, []string{"artist:Someone", "long　hair,", "", "__"}},
+		{bulk.Normalizer{TrimPunctuation: true}, []string{"Artist:Someone", "long　hair", "", "__"}},
+		{bulk.FullNormalizer, []string{"artist:someone", "long_hair"}},
+		{bulk.DefaultNormalizer, []string{"Artist:Someone", "long　hair,", "", "__"}},
+	}
+	for _, tt := range tests {
+		if got, want := tt.n.Tags(tags), tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v Tags(%q) => %q, want %q", tt.n, tags, got, want)
+		}
+	}
+}
+
+func TestNormalizer_Images(t *testing.T) {
+	images := []bulk.Image{{Name: "a", Tags: []string{"Tag1", ""}}}
+	got := bulk.FullNormalizer.Images(images)
+	if want := []bulk.Image{{Name: "a", Tags: []string{"tag1"}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Images(%v) => %v, want %v", images, got, want)
+	}
+	if want := []string{"Tag1", ""}; !reflect.DeepEqual(images[0].Tags, want) {
+		t.Errorf("Images modified its input to %v", images)
+	}
+}
+
+func TestSplitTags(t *testing.T) {
+	in := " tag1\ttag2\r\nlong　hair  foo,bar,"
+	got := bulk.SplitTags(in)
+	if want := []string{"tag1", "tag2", "long", "hair", "foo", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("SplitTags(%q) => %q, want %q", in, got, want)
+	}
+	if got := bulk.DefaultNormalizer.SplitTags(in); !reflect.DeepEqual(got, bulk.SplitTags(in)) {
+		t.Errorf("DefaultNormalizer.SplitTags(%q) => %q, want %q", in, got, bulk.SplitTags(in))
+	}
+	got = bulk.Normalizer{Spaces: true}.SplitTags(in)
+	if want := []string{"tag1", "tag2", "long　hair", "foo", "bar"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Normalizer{Spaces: true}.SplitTags(%q) => %q, want %q", in, got, want)
+	}
+}
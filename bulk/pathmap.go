@@ -0,0 +1,88 @@
+package bulk
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathRule maps a local directory to the directory where its files end up on
+// the server, for example local "D:\art\batch3" to server
+// "/var/shimmie/import/batch3".
+type PathRule struct {
+	Local  string `json:"local"`
+	Server string `json:"server"`
+}
+
+// PathMap is a set of path rules. When more than one rule matches a path, the
+// one with the longest directory wins.
+type PathMap []PathRule
+
+// Validate reports the first rule with an empty side.
+func (pm PathMap) Validate() error {
+	for _, r := range pm {
+		if r.Local == "" || r.Server == "" {
+			return fmt.Errorf("path rule %q -> %q: both the local and the server path are required", r.Local, r.Server)
+		}
+	}
+	return nil
+}
+
+// slashPath turns a path of any platform to a clean path separated by '/'.
+func slashPath(p string) string {
+	return path.Clean(strings.Replace(p, "\\", "/", -1))
+}
+
+// trimDir returns the part of p below dir and whether p is dir or is under
+// it. Both should be slash paths.
+func trimDir(p, dir string) (string, bool) {
+	if p == dir {
+		return "", true
+	}
+	if dir == "/" {
+		return strings.TrimPrefix(p, "/"), strings.HasPrefix(p, "/")
+	}
+	if strings.HasPrefix(p, dir+"/") {
+		return p[len(dir)+1:], true
+	}
+	return "", false
+}
+
+// convert finds the rule whose from side is the longest directory of p and
+// returns p under the to side of that rule.
+func (pm PathMap) convert(p string, from, to func(PathRule) string) (string, PathRule, bool) {
+	p = slashPath(p)
+	var (
+		best     PathRule
+		bestDir  string
+		bestRest string
+		found    bool
+	)
+	for _, r := range pm {
+		dir := slashPath(from(r))
+		rest, ok := trimDir(p, dir)
+		if ok && (!found || len(dir) > len(bestDir)) {
+			best, bestDir, bestRest, found = r, dir, rest, true
+		}
+	}
+	if !found {
+		return "", PathRule{}, false
+	}
+	return path.Join(slashPath(to(best)), bestRest), best, true
+}
+
+// ServerPath returns the server path of the local path p. Paths are separated
+// by '/' unless the server side of the rule uses '\'.
+func (pm PathMap) ServerPath(p string) (string, bool) {
+	sp, r, ok := pm.convert(p, func(r PathRule) string { return r.Local }, func(r PathRule) string { return r.Server })
+	if ok && strings.Contains(r.Server, "\\") && !strings.Contains(r.Server, "/") {
+		sp = strings.Replace(sp, "/", "\\", -1)
+	}
+	return sp, ok
+}
+
+// LocalPath returns the local path, separated by '/', of the server path p.
+func (pm PathMap) LocalPath(p string) (string, bool) {
+	lp, _, ok := pm.convert(p, func(r PathRule) string { return r.Server }, func(r PathRule) string { return r.Local })
+	return lp, ok
+}
@@ -0,0 +1,114 @@
+package bulk_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var testPaths = bulk.PathMap{
+	{Local: `D:\art\batch3`, Server: "/var/shimmie/import/batch3"},
+	{Local: `D:\art\batch3\special`, Server: "/var/shimmie/special"},
+	{Local: "/home/user/pics", Server: `C:\import\pics`},
+}
+
+var serverPathTests = []struct {
+	in  string
+	out string
+	ok  bool
+}{
+	{`D:\art\batch3\pic1.jpg`, "/var/shimmie/import/batch3/pic1.jpg", true},
+	{`D:\art\batch3\sub\pic1.jpg`, "/var/shimmie/import/batch3/sub/pic1.jpg", true},
+	{"D:/art/batch3/pic1.jpg", "/var/shimmie/import/batch3/pic1.jpg", true},
+	{`D:\art\batch3\special\pic1.jpg`, "/var/shimmie/special/pic1.jpg", true},
+	{`D:\art\batch30\pic1.jpg`, "", false},
+	{"/home/user/pics/pic1.jpg", `C:\import\pics\pic1.jpg`, true},
+	{"/home/user/other/pic1.jpg", "", false},
+}
+
+func TestPathMap_ServerPath(t *testing.T) {
+	for _, tt := range serverPathTests {
+		out, ok := testPaths.ServerPath(tt.in)
+		if out != tt.out || ok != tt.ok {
+			t.Errorf("ServerPath(%q) => %q, %v, want %q, %v", tt.in, out, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestPathMap_LocalPath(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+		ok  bool
+	}{
+		{"/var/shimmie/import/batch3/pic1.jpg", "D:/art/batch3/pic1.jpg", true},
+		{"/var/shimmie/special/pic1.jpg", "D:/art/batch3/special/pic1.jpg", true},
+		{`C:\import\pics\sub\pic1.jpg`, "/home/user/pics/sub/pic1.jpg", true},
+		{"/var/shimmie/other/pic1.jpg", "", false},
+	}
+	for _, tt := range tests {
+		out, ok := testPaths.LocalPath(tt.in)
+		if out != tt.out || ok != tt.ok {
+			t.Errorf("LocalPath(%q) => %q, %v, want %q, %v", tt.in, out, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestPathMap_Validate(t *testing.T) {
+	if err := testPaths.Validate(); err != nil {
+		t.Errorf("Validate() of valid rules returned err: %v", err)
+	}
+	if err := (bulk.PathMap{{Local: "/a"}}).Validate(); err == nil {
+		t.Errorf("Validate() of rule without server path expected to return err")
+	}
+}
+
+func TestWriter_Paths(t *testing.T) {
+	images := []bulk.Image{
+		{Name: "pic1.jpg", Tags: []string{"b", "a"}, Rating: "s"},
+		{Name: "special/pic2.jpg"},
+	}
+	var buf bytes.Buffer
+	w := bulk.NewWriter(&buf)
+	w.Dir = `D:\art\batch3`
+	w.Paths = testPaths
+	// Prefix is not used with path rules.
+	w.Prefix = "/ignored"
+	if err := w.WriteAll(images); err != nil {
+		t.Fatalf("WriteAll returned err: %v", err)
+	}
+	want := "/var/shimmie/import/batch3/pic1.jpg,a b,,s,\n" +
+		"/var/shimmie/special/pic2.jpg,,,,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteAll wrote\n%q\nwant\n%q", got, want)
+	}
+
+	w.Dir = "/unmapped"
+	if err := w.WriteAll(images); err == nil {
+		t.Errorf("WriteAll of images without path rule expected to return err")
+	}
+}
+
+func TestReader_Paths(t *testing.T) {
+	in := "/var/shimmie/import/batch3/pic1.jpg,a,,s,\n" +
+		"/var/shimmie/special/pic2.jpg,b,,s,\n" +
+		"/elsewhere/batch3/sub/pic3.jpg,c,,s,\n"
+	r := bulk.NewReader(strings.NewReader(in))
+	r.Dir = "D:/art/batch3"
+	r.Paths = testPaths
+	images, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll returned err: %v", err)
+	}
+	var names []string
+	for _, img := range images {
+		names = append(names, img.Name)
+	}
+	// Paths without rule fall back to the base of Dir.
+	if want := []string{"pic1.jpg", "special/pic2.jpg", "sub/pic3.jpg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ReadAll with path rules => names %q, want %q", names, want)
+	}
+}
@@ -0,0 +1,209 @@
+package bulk
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	// Decoders for the image formats that can be perceptually hashed.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// DHash computes the difference hash of an image. The image is shrunk to 9x8
+// gray cells and each bit of the hash tells whether a cell is darker than its
+// neighbour on the right. Resized or re-encoded copies of the same picture end
+// up with the same or a very close hash.
+func DHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	// At most samples x samples pixels are averaged for each cell, which is
+	// plenty to get its brightness without visiting every pixel of big
+	// images.
+	const samples = 16
+
+	b := img.Bounds()
+	var gray [h][w]uint64
+	for cy := 0; cy < h; cy++ {
+		y0, y1 := cellRange(b.Min.Y, b.Dy(), cy, h)
+		for cx := 0; cx < w; cx++ {
+			x0, x1 := cellRange(b.Min.X, b.Dx(), cx, w)
+			var sum, n uint64
+			for y := y0; y < y1; y += step(y1-y0, samples) {
+				for x := x0; x < x1; x += step(x1-x0, samples) {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+					n++
+				}
+			}
+			if n != 0 {
+				gray[cy][cx] = sum / n
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray[y][x] < gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// cellRange returns the pixel range [start, end) of cell i when a length of
+// size, starting at min, is divided in n cells. Cells are never empty unless
+// size is zero.
+func cellRange(min, size, i, n int) (int, int) {
+	start := min + i*size/n
+	end := min + (i+1)*size/n
+	if end <= start && size > 0 {
+		end = start + 1
+		if end > min+size {
+			start, end = min+size-1, min+size
+		}
+	}
+	return start, end
+}
+
+func step(size, samples int) int {
+	if s := size / samples; s > 1 {
+		return s
+	}
+	return 1
+}
+
+// FormatPHash returns the form used in Image.PHash for a perceptual hash.
+func FormatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Distance returns the Hamming distance of two perceptual hashes as set in
+// Image.PHash. It returns false if any of them is not a valid hash.
+func Distance(a, b string) (int, bool) {
+	ha, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	hb, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return bits.OnesCount64(ha ^ hb), true
+}
+
+// PHashes maps the SHA1 of image files to their perceptual hash. It is used
+// as a cache since decoding every image is slow.
+type PHashes map[string]string
+
+// LoadPHashes reads perceptual hashes that were previously written by
+// SavePHashes.
+func LoadPHashes(file io.Reader) (PHashes, error) {
+	phashes := make(PHashes)
+	if err := json.NewDecoder(file).Decode(&phashes); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("decode perceptual hashes: %v", err)
+	}
+	return phashes, nil
+}
+
+// SavePHashes writes the perceptual hashes to an open for writing file.
+func SavePHashes(file io.Writer, phashes PHashes) error {
+	enc := json.NewEncoder(file)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(phashes); err != nil {
+		return fmt.Errorf("encode perceptual hashes: %v", err)
+	}
+	return nil
+}
+
+// PerceptualHashImages sets the PHash of each image under dir that can be
+// decoded. Images need to have their SHA1 set by HashImages as the SHA1 is
+// used to look up and store hashes in the provided cache. Images that cannot
+// be decoded, like videos, are left without PHash and so are files that
+// cannot be read, which are tried again on the next call. It reports whether
+// hashes were added to the cache.
+func PerceptualHashImages(dir string, images []Image, cache PHashes) bool {
+	changed := false
+	for i := range images {
+		images[i].PHash = ""
+		if images[i].SHA1 == "" {
+			continue
+		}
+		if h, ok := cache[images[i].SHA1]; ok {
+			images[i].PHash = h
+			continue
+		}
+		if t, _ := MediaTypes.Lookup(images[i].Name); t.Preview != PreviewImage {
+			continue
+		}
+		h, err := perceptualHashFile(filepath.Join(dir, filepath.FromSlash(images[i].Name)))
+		if err != nil {
+			continue
+		}
+		// Undecodable files are cached too, with an empty hash, so that
+		// they are not decoded again.
+		cache[images[i].SHA1] = h
+		changed = true
+		images[i].PHash = h
+	}
+	return changed
+}
+
+func perceptualHashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", nil
+	}
+	return FormatPHash(DHash(img)), nil
+}
+
+// Pair is two images with similar content.
+type Pair struct {
+	A, B     Image
+	Distance int
+}
+
+// Similar returns the pairs of images whose perceptual hashes are within
+// maxDistance of each other, closest first. Images with identical content are
+// left out since they are reported by Duplicates.
+func Similar(images []Image, maxDistance int) []Pair {
+	var pairs []Pair
+	for i := range images {
+		for j := i + 1; j < len(images); j++ {
+			a, b := images[i], images[j]
+			if a.SHA1 != "" && a.SHA1 == b.SHA1 {
+				continue
+			}
+			d, ok := Distance(a.PHash, b.PHash)
+			if !ok || d > maxDistance {
+				continue
+			}
+			if b.Name < a.Name {
+				a, b = b, a
+			}
+			pairs = append(pairs, Pair{A: a, B: b, Distance: d})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Distance != pairs[j].Distance {
+			return pairs[i].Distance < pairs[j].Distance
+		}
+		return pairs[i].A.Name < pairs[j].A.Name
+	})
+	return pairs
+}
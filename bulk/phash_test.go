@@ -0,0 +1,160 @@
+package bulk_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+// gradient returns an image that gets brighter from left to right or, if
+// reverse is set, from right to left.
+func gradient(w, h int, reverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(x * 255 / w)
+			if reverse {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func solid(w, h int, c color.Color) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+var dhashTests = []struct {
+	in  image.Image
+	out uint64
+}{
+	{gradient(90, 80, false), 0xffffffffffffffff},
+	{gradient(900, 30, false), 0xffffffffffffffff},
+	{gradient(90, 80, true), 0},
+	{solid(16, 16, color.RGBA{0, 255, 0, 255}), 0},
+	{solid(3, 2, color.RGBA{255, 0, 0, 255}), 0},
+	{image.NewGray(image.Rect(0, 0, 0, 0)), 0},
+}
+
+func TestDHash(t *testing.T) {
+	for _, tt := range dhashTests {
+		if got, want := bulk.DHash(tt.in), tt.out; got != want {
+			t.Errorf("DHash(%v) => %x, want %x", tt.in.Bounds(), got, want)
+		}
+	}
+}
+
+var distanceTests = []struct {
+	a, b string
+	out  int
+	ok   bool
+}{
+	{"0000000000000000", "0000000000000000", 0, true},
+	{"0000000000000000", "ffffffffffffffff", 64, true},
+	{"00000000000000ff", "000000000000000f", 4, true},
+	{"", "0000000000000000", 0, false},
+	{"0000000000000000", "xyz", 0, false},
+}
+
+func TestDistance(t *testing.T) {
+	for _, tt := range distanceTests {
+		got, ok := bulk.Distance(tt.a, tt.b)
+		if got != tt.out || ok != tt.ok {
+			t.Errorf("Distance(%q, %q) => %v, %v, want %v, %v", tt.a, tt.b, got, ok, tt.out, tt.ok)
+		}
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	images := []bulk.Image{
+		{Name: "d", SHA1: "4", PHash: "00000000000000ff"},
+		{Name: "c", SHA1: "3", PHash: "0000000000000000"},
+		{Name: "b", SHA1: "2", PHash: "0000000000000001"},
+		{Name: "a", SHA1: "1", PHash: "0000000000000000"},
+		{Name: "e", SHA1: "1", PHash: "0000000000000000"},
+		{Name: "f", SHA1: "5"},
+	}
+	got := bulk.Similar(images, 1)
+	want := []bulk.Pair{
+		{A: images[3], B: images[1], Distance: 0},
+		{A: images[1], B: images[4], Distance: 0},
+		{A: images[3], B: images[2], Distance: 1},
+		{A: images[2], B: images[1], Distance: 1},
+		{A: images[2], B: images[4], Distance: 1},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Similar(%v, 1) =>\n%v\nwant\n%v", images, got, want)
+	}
+}
+
+func TestCopyFields(t *testing.T) {
+	src := bulk.Image{Name: "src", Tags: []string{"t1"}, Source: "source", Rating: "e"}
+	dst := bulk.Image{Name: "dst", Tags: []string{"t2"}, Source: "other", Rating: "s"}
+	bulk.CopyFields(&dst, src, bulk.FieldTags, bulk.FieldRating)
+	want := bulk.Image{Name: "dst", Tags: []string{"t1"}, Source: "other", Rating: "e"}
+	if !reflect.DeepEqual(dst, want) {
+		t.Errorf("CopyFields => %v, want %v", dst, want)
+	}
+}
+
+func TestPerceptualHashImages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, gradient(16, 16, false)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.png"), b.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "bad.png"), []byte("<html>error</html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	images := []bulk.Image{
+		{Name: "a.png", SHA1: "a"},
+		{Name: "bad.png", SHA1: "bad"},
+		{Name: "missing.png", SHA1: "missing"},
+		{Name: "nohash.png"},
+	}
+	cache := make(bulk.PHashes)
+	if !bulk.PerceptualHashImages(dir, images, cache) {
+		t.Errorf("PerceptualHashImages of new files reported no change")
+	}
+	want := bulk.FormatPHash(bulk.DHash(gradient(16, 16, false)))
+	if images[0].PHash != want {
+		t.Errorf("PerceptualHashImages => %q, want %q", images[0].PHash, want)
+	}
+	for _, img := range images[1:] {
+		if img.PHash != "" {
+			t.Errorf("PerceptualHashImages of %v => %q, want none", img.Name, img.PHash)
+		}
+	}
+	// Undecodable files are cached so that they are not decoded again but
+	// unreadable files are not.
+	if want := (bulk.PHashes{"a": want, "bad": ""}); !reflect.DeepEqual(cache, want) {
+		t.Errorf("PerceptualHashImages cache => %v, want %v", cache, want)
+	}
+	if bulk.PerceptualHashImages(dir, images, cache) {
+		t.Errorf("PerceptualHashImages of cached files reported a change")
+	}
+}
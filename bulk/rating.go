@@ -0,0 +1,126 @@
+package bulk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Rating is one of the ratings an image can have.
+type Rating struct {
+	// Value is the rating as written in the CSV file, like "s".
+	Value string `json:"value"`
+	// Name is the rating as shown to people, like "Safe".
+	Name string `json:"name"`
+}
+
+// RatingScheme is the set of ratings accepted by a board, in the order they
+// are shown.
+type RatingScheme []Rating
+
+// DefaultRatings are the ratings of Kusubooru and older Shimmie2 versions.
+var DefaultRatings = RatingScheme{
+	{Value: "s", Name: "Safe"},
+	{Value: "q", Name: "Questionable"},
+	{Value: "e", Name: "Explicit"},
+}
+
+// Ratings are the ratings accepted in a CSV file. An empty rating means the
+// image has not been rated yet.
+var Ratings = DefaultRatings
+
+// Validate reports the first rating with an invalid or repeated value or
+// without a name.
+func (rs RatingScheme) Validate() error {
+	if len(rs) == 0 {
+		return fmt.Errorf("rating scheme has no ratings")
+	}
+	values := make(map[string]struct{}, len(rs))
+	for _, r := range rs {
+		if r.Value == "" || strings.ContainsAny(r.Value, " \t\r\n,\"") {
+			return fmt.Errorf("rating %q: value may not be empty or have spaces, commas or quotes", r.Value)
+		}
+		if r.Value != strings.ToLower(r.Value) {
+			return fmt.Errorf("rating %q: value must be lower case", r.Value)
+		}
+		if r.Name == "" {
+			return fmt.Errorf("rating %q: name is required", r.Value)
+		}
+		if _, ok := values[r.Value]; ok {
+			return fmt.Errorf("rating %q is defined more than once", r.Value)
+		}
+		values[r.Value] = struct{}{}
+	}
+	return nil
+}
+
+// Values returns the values of the ratings.
+func (rs RatingScheme) Values() []string {
+	values := make([]string, 0, len(rs))
+	for _, r := range rs {
+		values = append(values, r.Value)
+	}
+	return values
+}
+
+// Equal reports whether both schemes have the same values in the same order.
+func (rs RatingScheme) Equal(other RatingScheme) bool {
+	if len(rs) != len(other) {
+		return false
+	}
+	for i := range rs {
+		if rs[i].Value != other[i].Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Valid reports whether value is one of the ratings or empty.
+func (rs RatingScheme) Valid(value string) bool {
+	if value == "" {
+		return true
+	}
+	_, ok := rs.find(value)
+	return ok
+}
+
+// Name returns the name of the rating with value or an empty string if there
+// is no such rating.
+func (rs RatingScheme) Name(value string) string {
+	r, _ := rs.find(value)
+	return r.Name
+}
+
+// Lookup returns the value of the rating whose value or name, ignoring case,
+// is s.
+func (rs RatingScheme) Lookup(s string) (string, bool) {
+	for _, r := range rs {
+		if strings.EqualFold(r.Value, s) || strings.EqualFold(r.Name, s) {
+			return r.Value, true
+		}
+	}
+	return "", false
+}
+
+func (rs RatingScheme) find(value string) (Rating, bool) {
+	for _, r := range rs {
+		if r.Value == value {
+			return r, true
+		}
+	}
+	return Rating{}, false
+}
+
+// RemapRatings replaces the ratings of the images found in m by the rating
+// they map to and returns the number of images changed. All the ratings are
+// replaced at once so m can swap values, like "s" to "g" and "q" to "s".
+func RemapRatings(images []Image, m map[string]string) int {
+	n := 0
+	for i := range images {
+		if to, ok := m[images[i].Rating]; ok && to != images[i].Rating {
+			images[i].Rating = to
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,96 @@
+package bulk_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var danbooruRatings = bulk.RatingScheme{
+	{Value: "g", Name: "General"},
+	{Value: "s", Name: "Sensitive"},
+	{Value: "q", Name: "Questionable"},
+	{Value: "e", Name: "Explicit"},
+	{Value: "?", Name: "Unrated"},
+}
+
+func TestRatingScheme_Validate(t *testing.T) {
+	if err := danbooruRatings.Validate(); err != nil {
+		t.Errorf("Validate() of valid scheme returned err: %v", err)
+	}
+	invalid := []bulk.RatingScheme{
+		nil,
+		{{Value: "", Name: "Empty"}},
+		{{Value: "s", Name: ""}},
+		{{Value: "S", Name: "Safe"}},
+		{{Value: "a,b", Name: "Comma"}},
+		{{Value: "s", Name: "Safe"}, {Value: "s", Name: "Sensitive"}},
+	}
+	for _, rs := range invalid {
+		if err := rs.Validate(); err == nil {
+			t.Errorf("Validate() of %v expected to return err", rs)
+		}
+	}
+}
+
+func TestRatingScheme_Lookup(t *testing.T) {
+	tests := []struct {
+		in    string
+		value string
+		ok    bool
+	}{
+		{"g", "g", true},
+		{"General", "g", true},
+		{"unrated", "?", true},
+		{"safe", "", false},
+	}
+	for _, tt := range tests {
+		value, ok := danbooruRatings.Lookup(tt.in)
+		if value != tt.value || ok != tt.ok {
+			t.Errorf("Lookup(%q) => %q, %v, want %q, %v", tt.in, value, ok, tt.value, tt.ok)
+		}
+	}
+	if got, want := danbooruRatings.Name("s"), "Sensitive"; got != want {
+		t.Errorf("Name(%q) => %q, want %q", "s", got, want)
+	}
+}
+
+func TestRemapRatings(t *testing.T) {
+	images := []bulk.Image{{Rating: "s"}, {Rating: "q"}, {Rating: "e"}, {Rating: ""}}
+	n := bulk.RemapRatings(images, map[string]string{"s": "g", "q": "s", "": "?"})
+	var got []string
+	for _, img := range images {
+		got = append(got, img.Rating)
+	}
+	if want := []string{"g", "s", "e", "?"}; !reflect.DeepEqual(got, want) || n != 3 {
+		t.Errorf("RemapRatings => %q, %d, want %q, 3", got, n, want)
+	}
+}
+
+func TestReader_Ratings(t *testing.T) {
+	in := "/server/dir/img1,,,g,\n/server/dir/img2,,,?,\n"
+	r := bulk.NewReader(strings.NewReader(in))
+	r.Ratings = danbooruRatings
+	images, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll with custom ratings returned err: %v", err)
+	}
+	if len(images) != 2 || images[0].Rating != "g" || images[1].Rating != "?" {
+		t.Errorf("ReadAll with custom ratings => %v", images)
+	}
+
+	r = bulk.NewReader(strings.NewReader("/server/dir/img1,,,General,\n"))
+	r.Ratings = danbooruRatings
+	r.Lenient = true
+	images, err = r.ReadAll()
+	if err != nil || len(images) != 1 || images[0].Rating != "g" {
+		t.Errorf("lenient ReadAll of rating name => %v, %v, want rating %q", images, err, "g")
+	}
+
+	r = bulk.NewReader(strings.NewReader(in))
+	if _, err := r.ReadAll(); err == nil {
+		t.Errorf("ReadAll with default ratings of custom ratings expected to return err")
+	}
+}
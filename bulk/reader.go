@@ -0,0 +1,319 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Reader reads image metadata from a CSV file.
+//
+// By default the file must have the exact format written by Save. Setting
+// Lenient accepts CSV files written by other tools or other versions of
+// Shimmie2.
+type Reader struct {
+	// Dir, if set, keeps the image names relative to the base of Dir
+	// instead of keeping only the filename. See LoadCSVRecursive.
+	Dir string
+
+	// Prefix is the server path that comes before the base of Dir, as
+	// written by Writer. The names are the paths below Prefix and the base
+	// of Dir and records that are not under them are reported. If Prefix is
+	// empty, ReadAll sets it from the first record, as the path before the
+	// first folder named like the base of Dir. It is only used with Dir.
+	Prefix string
+
+	// Paths, if set, maps the server paths of the file to local paths. The
+	// name of an image whose local path is under Dir is its path relative to
+	// Dir. Other paths fall back to the naming described for Dir.
+	Paths PathMap
+
+	// Lenient accepts files with a header row, with fewer than five columns
+	// or with extra columns. When a header row is found, columns are mapped
+	// by name. Missing columns are left empty and unknown columns are kept in
+	// Image.Extra so that Save can write them back. Ratings spelled out by
+	// their name, like "safe", are accepted too.
+	Lenient bool
+
+	// Ratings are the ratings accepted in the file. If nil, the package
+	// Ratings are used.
+	Ratings RatingScheme
+
+	// Header is the header row of a file read in lenient mode, with its
+	// columns in the order Writer writes them: path, tags, source, rating
+	// and thumbnail followed by the unknown columns. Missing columns get
+	// their default name. ReadAll sets it if the file has a header row.
+	Header []string
+
+	// FirstPath is the path of the first record that has one, as written
+	// in the file. ReadAll sets it.
+	FirstPath string
+
+	r           io.Reader
+	prefixFound bool
+}
+
+// NewReader returns a new Reader that reads from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// columns holds the position of each known column in a record, or -1 if the
+// record does not have it.
+type columns struct {
+	path, tags, source, rating, thumbnail int
+	// extra are the positions of the unknown columns.
+	extra []int
+}
+
+// strictColumns are the columns of the format written by Save. The last
+// column (thumbnail) is ignored.
+var strictColumns = columns{path: 0, tags: 1, source: 2, rating: 3, thumbnail: 4}
+
+// columnDefaults are the names of the known columns in the order Writer
+// writes them.
+var columnDefaults = []string{"path", "tags", "source", "rating", "thumbnail"}
+
+// positionalColumns returns the columns of a record of size n without header
+// where the known columns are in the order written by Save, followed by any
+// unknown ones.
+func positionalColumns(n int) columns {
+	c := columns{path: -1, tags: -1, source: -1, rating: -1, thumbnail: -1}
+	for i, p := range []*int{&c.path, &c.tags, &c.source, &c.rating, &c.thumbnail} {
+		if i < n {
+			*p = i
+		}
+	}
+	for i := 5; i < n; i++ {
+		c.extra = append(c.extra, i)
+	}
+	return c
+}
+
+// columnNames maps known header names, lowercased and without spaces, dashes
+// or underscores, to the columns they stand for.
+var columnNames = map[string]string{
+	"path":      "path",
+	"file":      "path",
+	"filename":  "path",
+	"filepath":  "path",
+	"image":     "path",
+	"imagepath": "path",
+	"tags":      "tags",
+	"tag":       "tags",
+	"source":    "source",
+	"url":       "source",
+	"rating":    "rating",
+	"thumbnail": "thumbnail",
+	"thumb":     "thumbnail",
+}
+
+func columnName(field string) string {
+	field = strings.ToLower(strings.TrimSpace(field))
+	field = strings.NewReplacer(" ", "", "-", "", "_", "").Replace(field)
+	return columnNames[field]
+}
+
+// headerColumns reports whether record is a header row and if so returns the
+// columns it names. A record is a header when it names the path column and at
+// least one other known column.
+func headerColumns(record []string) (columns, bool) {
+	c := columns{path: -1, tags: -1, source: -1, rating: -1, thumbnail: -1}
+	known := 0
+	for i, field := range record {
+		var p *int
+		switch columnName(field) {
+		case "path":
+			p = &c.path
+		case "tags":
+			p = &c.tags
+		case "source":
+			p = &c.source
+		case "rating":
+			p = &c.rating
+		case "thumbnail":
+			if c.thumbnail == -1 {
+				c.thumbnail = i
+			}
+			known++
+			continue
+		}
+		if p == nil || *p != -1 {
+			c.extra = append(c.extra, i)
+			continue
+		}
+		*p = i
+		known++
+	}
+	return c, c.path != -1 && known >= 2
+}
+
+// header returns the names of the columns c of the header row record in the
+// order Writer writes them.
+func (c columns) header(record []string) []string {
+	var h []string
+	for i, p := range []int{c.path, c.tags, c.source, c.rating, c.thumbnail} {
+		name := columnDefaults[i]
+		if p != -1 {
+			name = record[p]
+		}
+		h = append(h, name)
+	}
+	for _, i := range c.extra {
+		h = append(h, record[i])
+	}
+	return h
+}
+
+// field returns the value of column i of record or an empty string if the
+// record does not have it.
+func field(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+// ReadAll reads all the remaining records and returns them as images. If the
+// file does not have the expected format, a *ValidationError listing every
+// problem found is returned.
+func (r *Reader) ReadAll() ([]Image, error) {
+	images := []Image{}
+
+	v := validator{ratings: r.Ratings}
+	if v.ratings == nil {
+		v.ratings = Ratings
+	}
+	cr := csv.NewReader(r.r)
+	// Records with the wrong number of fields are reported by the validator
+	// along with every other problem.
+	cr.FieldsPerRecord = -1
+	var header *columns
+	first := true
+	for {
+		record, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		// The reader cannot recover from a malformed record so it is the
+		// last problem reported. Its column is a character position and not
+		// a field.
+		if perr, ok := err.(*csv.ParseError); ok {
+			v.add(perr.Line, 0, "%v at character %d", perr.Err, perr.Column)
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+
+		c := strictColumns
+		switch {
+		case r.Lenient && first:
+			first = false
+			if hc, ok := headerColumns(record); ok {
+				header = &hc
+				r.Header = hc.header(record)
+				continue
+			}
+			c = positionalColumns(len(record))
+		case r.Lenient && header != nil:
+			c = *header
+		case r.Lenient:
+			c = positionalColumns(len(record))
+		default:
+			if len(record) != 5 {
+				v.add(line, 0, "expected 5 fields, got %d", len(record))
+				continue
+			}
+		}
+
+		rating := field(record, c.rating)
+		if r.Lenient {
+			rating = strings.TrimSpace(rating)
+			if value, ok := v.ratings.Lookup(rating); ok {
+				rating = value
+			}
+			rating = strings.ToLower(rating)
+		}
+		img := Image{
+			Name:   field(record, c.path),
+			Tags:   strings.Split(field(record, c.tags), " "),
+			Source: field(record, c.source),
+			Rating: rating,
+		}
+		for _, i := range c.extra {
+			img.Extra = append(img.Extra, field(record, i))
+		}
+		valid := v.validate(line, c, img)
+		// Image filepath (first column) should exist otherwise we cannot match
+		// the metadata with the images found under the directory.
+		if img.Name != "" {
+			serverPath := img.Name
+			if valid && r.FirstPath == "" {
+				r.FirstPath = img.Name
+			}
+			if name, ok := r.mappedName(img.Name); ok {
+				img.Name = name
+			} else if r.Dir != "" {
+				name, err := r.relativeName(img.Name)
+				if err != nil {
+					v.add(line, c.path+1, "%v", err)
+					continue
+				}
+				img.Name = name
+			} else {
+				img.Name = filepath.Base(img.Name)
+			}
+			if !v.unique(line, c, serverPath, img.Name) || !valid {
+				continue
+			}
+			images = append(images, img)
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// mappedName returns the name of the image with the server path p using the
+// path rules.
+func (r *Reader) mappedName(p string) (string, bool) {
+	if len(r.Paths) == 0 {
+		return "", false
+	}
+	local, ok := r.Paths.LocalPath(p)
+	if !ok {
+		return "", false
+	}
+	if r.Dir == "" {
+		return path.Base(local), true
+	}
+	rel, ok := trimDir(local, slashPath(r.Dir))
+	if !ok || rel == "" {
+		return "", false
+	}
+	return rel, true
+}
+
+// relativeName returns the part of serverPath below Prefix and the base of
+// Dir, using '/' as separator.
+func (r *Reader) relativeName(serverPath string) (string, error) {
+	base := filepath.Base(r.Dir)
+	if r.Prefix == "" && !r.prefixFound {
+		r.Prefix, r.prefixFound = findPrefix(serverPath, base)
+		if !r.prefixFound {
+			return "", fmt.Errorf("path %q is not under a folder named %q", serverPath, base)
+		}
+	}
+	dir := path.Join(slashPath(r.Prefix), base)
+	rel, ok := trimDir(slashPath(serverPath), dir)
+	if !ok || rel == "" {
+		return "", fmt.Errorf("path %q is not under %q", serverPath, dir)
+	}
+	return rel, nil
+}
@@ -0,0 +1,172 @@
+package bulk_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var lenientReaderTests = []struct {
+	in  string
+	out []bulk.Image
+	err error
+}{
+	{"", []bulk.Image{}, nil},
+	// Same format as the one written by Save.
+	{
+		"/server/dir/img1,tag1 tag2,source,s,",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1", "tag2"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	// Four columns.
+	{
+		"/server/dir/img1,tag1,source,q",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Source: "source", Rating: "q"}},
+		nil,
+	},
+	// Only path and tags.
+	{
+		"/server/dir/img1,tag1\n/server/dir/img2",
+		[]bulk.Image{
+			{Name: "img1", Tags: []string{"tag1"}},
+			{Name: "img2", Tags: []string{""}},
+		},
+		nil,
+	},
+	// Extra columns are kept.
+	{
+		"/server/dir/img1,tag1,source,e,,extra1,extra2",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Source: "source", Rating: "e", Extra: []string{"extra1", "extra2"}}},
+		nil,
+	},
+	// Header with columns in another order and an unknown column.
+	{
+		"Rating,Image Path,notes,tags,Source URL\nSafe,/server/dir/img1,a note,tag1,source\nexplicit,/server/dir/img2,,tag2,",
+		[]bulk.Image{
+			{Name: "img1", Tags: []string{"tag1"}, Rating: "s", Extra: []string{"a note", "source"}},
+			{Name: "img2", Tags: []string{"tag2"}, Rating: "e", Extra: []string{"", ""}},
+		},
+		nil,
+	},
+	// Header with thumbnail and missing rating.
+	{
+		"filename,tags,source,thumbnail\n/server/dir/img1,tag1,source,/thumb.jpg",
+		[]bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Source: "source"}},
+		nil,
+	},
+	// A first row that is not a header.
+	{
+		"tags,more tags,source,s,",
+		[]bulk.Image{{Name: "tags", Tags: []string{"more", "tags"}, Source: "source", Rating: "s"}},
+		nil,
+	},
+	// Validation still applies.
+	{
+		"path,rating\n/server/dir/img1,maybe",
+		nil,
+		&bulk.ValidationError{Problems: []bulk.Problem{
+			{Line: 2, Column: 2, Reason: `unknown rating "maybe", expected one of ["s" "q" "e"]`},
+		}},
+	},
+}
+
+func TestReader_lenient(t *testing.T) {
+	for _, tt := range lenientReaderTests {
+		r := bulk.NewReader(strings.NewReader(tt.in))
+		r.Lenient = true
+		got, err := r.ReadAll()
+		if want := tt.err; !reflect.DeepEqual(err, want) {
+			t.Errorf("lenient ReadAll(%q) returned err %v, want %v", tt.in, err, want)
+		}
+		if want := tt.out; !reflect.DeepEqual(got, want) {
+			t.Errorf("lenient ReadAll(%q) => %v, want %v", tt.in, got, want)
+		}
+	}
+}
+
+func TestReader_strictHeader(t *testing.T) {
+	in := "path,tags,source,rating,thumbnail\n/server/dir/img1,tag1,source,s,"
+	_, err := bulk.NewReader(strings.NewReader(in)).ReadAll()
+	want := &bulk.ValidationError{Problems: []bulk.Problem{
+		{Line: 1, Column: 4, Reason: `unknown rating "rating", expected one of ["s" "q" "e"]`},
+	}}
+	if !reflect.DeepEqual(err, want) {
+		t.Errorf("strict ReadAll(%q) returned err %v, want %v", in, err, want)
+	}
+}
+
+func TestSave_extra(t *testing.T) {
+	images := []bulk.Image{{Name: "img1", Tags: []string{"tag1"}, Rating: "s", Extra: []string{"a note", "x"}}}
+	var b bytes.Buffer
+	if err := bulk.Save(&b, images, "/local/dir", "/server", true); err != nil {
+		t.Fatalf("Save returned err %v", err)
+	}
+	if got, want := b.String(), "/server/dir/img1,tag1,,s,,a note,x\n"; got != want {
+		t.Errorf("Save(%v) => %q, want %q", images, got, want)
+	}
+}
+
+func TestReader_header(t *testing.T) {
+	in := "Rating,Image Path,notes,tags,Source URL\nSafe,/server/dir/img1,a note,tag1,source\n"
+	r := bulk.NewReader(strings.NewReader(in))
+	r.Lenient = true
+	images, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("lenient ReadAll(%q) returned err %v", in, err)
+	}
+	wantHeader := []string{"Image Path", "tags", "source", "Rating", "thumbnail", "notes", "Source URL"}
+	if !reflect.DeepEqual(r.Header, wantHeader) {
+		t.Errorf("lenient ReadAll(%q) => header %q, want %q", in, r.Header, wantHeader)
+	}
+
+	// The header is written back with the columns in the written order and
+	// the file reads back the same.
+	var b bytes.Buffer
+	w := bulk.NewWriter(&b)
+	w.Dir = "/local/dir"
+	w.Prefix = "/server"
+	w.UseLinuxSep = true
+	w.Header = r.Header
+	if err := w.WriteAll(images); err != nil {
+		t.Fatalf("WriteAll returned err %v", err)
+	}
+	want := "Image Path,tags,source,Rating,thumbnail,notes,Source URL\n/server/dir/img1,tag1,,s,,a note,source\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteAll with header => %q, want %q", got, want)
+	}
+	r = bulk.NewReader(strings.NewReader(b.String()))
+	r.Lenient = true
+	again, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("lenient ReadAll(%q) returned err %v", b.String(), err)
+	}
+	if !reflect.DeepEqual(again, images) {
+		t.Errorf("lenient ReadAll of written file => %v, want %v", again, images)
+	}
+	if !reflect.DeepEqual(r.Header, wantHeader) {
+		t.Errorf("lenient ReadAll of written file => header %q, want %q", r.Header, wantHeader)
+	}
+
+	// Rows without the extra columns are padded to the header.
+	b.Reset()
+	if err := w.WriteAll([]bulk.Image{{Name: "img2"}}); err != nil {
+		t.Fatalf("WriteAll returned err %v", err)
+	}
+	want = "Image Path,tags,source,Rating,thumbnail,notes,Source URL\n/server/dir/img2,,,,,,\n"
+	if got := b.String(); got != want {
+		t.Errorf("WriteAll with header of image without extra => %q, want %q", got, want)
+	}
+
+	// A file without header row leaves Header nil.
+	r = bulk.NewReader(strings.NewReader("/server/dir/img1,tag1,source,s,,extra\n"))
+	r.Lenient = true
+	if _, err := r.ReadAll(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Header != nil {
+		t.Errorf("lenient ReadAll without header row => header %q, want nil", r.Header)
+	}
+}
@@ -0,0 +1,166 @@
+package bulk
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// Rules are local tag aliases and implications like the ones kept by boorus.
+// An alias replaces a tag with another, for example "kancolle" with
+// "series:kantai_collection". An implication adds tags whenever a tag is
+// present, for example "character:shimakaze" implies
+// "series:kantai_collection".
+//
+// Rules match tags after normalization so they should be written in the
+// normalized form.
+type Rules struct {
+	Aliases      map[string]string   `json:"aliases"`
+	Implications map[string][]string `json:"implications"`
+}
+
+// TagChange is a change made to the tags of an image by Rules.
+type TagChange struct {
+	// Old is the tag that was replaced, or the tag that implied New if
+	// Implied is set.
+	Old string
+	// New is the tag that was put in place of Old or added.
+	New     string
+	Implied bool
+}
+
+// ParseRules reads rules either as a JSON object with "aliases" and
+// "implications" or as plain text with one rule per line:
+//
+//	# comment
+//	kancolle -> series:kantai_collection
+//	character:shimakaze => series:kantai_collection
+//
+// The right side of an implication may list more than one tag separated by
+// spaces.
+func ParseRules(r io.Reader) (*Rules, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	rules := &Rules{
+		Aliases:      make(map[string]string),
+		Implications: make(map[string][]string),
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) != 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, rules); err != nil {
+			return nil, fmt.Errorf("decode rules: %v", err)
+		}
+		for from, to := range rules.Aliases {
+			if strings.ContainsAny(from+to, " \t") || from == "" || to == "" {
+				return nil, fmt.Errorf("alias %q -> %q: expected a single tag on each side", from, to)
+			}
+		}
+		return rules, nil
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		if i := strings.Index(text, "->"); i != -1 {
+			from, to := strings.Fields(text[:i]), strings.Fields(text[i+2:])
+			if len(from) != 1 || len(to) != 1 {
+				return nil, fmt.Errorf("rules line %d: expected a single tag on each side of ->", line)
+			}
+			if old, ok := rules.Aliases[from[0]]; ok && old != to[0] {
+				return nil, fmt.Errorf("rules line %d: %v is already aliased to %v", line, from[0], old)
+			}
+			rules.Aliases[from[0]] = to[0]
+			continue
+		}
+		if i := strings.Index(text, "=>"); i != -1 {
+			from, to := strings.Fields(text[:i]), strings.Fields(text[i+2:])
+			if len(from) != 1 || len(to) == 0 {
+				return nil, fmt.Errorf("rules line %d: expected a single tag before => and at least one after", line)
+			}
+			rules.Implications[from[0]] = append(rules.Implications[from[0]], to...)
+			continue
+		}
+		return nil, fmt.Errorf("rules line %d: expected -> or =>", line)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// alias follows the aliases of tag and returns the tag it ends up as. Alias
+// cycles stop once every alias was followed.
+func (r *Rules) alias(tag string) string {
+	for i := 0; i < len(r.Aliases); i++ {
+		to, ok := r.Aliases[tag]
+		if !ok || to == tag {
+			break
+		}
+		tag = to
+	}
+	return tag
+}
+
+// Tags applies the rules to tags. It returns the resulting tags and the
+// changes that were made. Tags added by implications are aliased and their own
+// implications are added too.
+func (r *Rules) Tags(tags []string) ([]string, []TagChange) {
+	if r == nil {
+		return tags, nil
+	}
+	var changes []TagChange
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		if t == "" {
+			out = append(out, t)
+			continue
+		}
+		if a := r.alias(t); a != t {
+			changes = append(changes, TagChange{Old: t, New: a})
+			t = a
+		}
+		if _, ok := seen[t]; ok {
+			continue
+		}
+		seen[t] = struct{}{}
+		out = append(out, t)
+	}
+	for i := 0; i < len(out); i++ {
+		for _, implied := range r.Implications[out[i]] {
+			implied = r.alias(implied)
+			if _, ok := seen[implied]; ok {
+				continue
+			}
+			seen[implied] = struct{}{}
+			changes = append(changes, TagChange{Old: out[i], New: implied, Implied: true})
+			out = append(out, implied)
+		}
+	}
+	return out, changes
+}
+
+// Images returns a copy of images with the rules applied to their tags along
+// with the changes made, by image name. Images without changes are not in the
+// map.
+func (r *Rules) Images(images []Image) ([]Image, map[string][]TagChange) {
+	out := make([]Image, len(images))
+	changes := make(map[string][]TagChange)
+	for i, img := range images {
+		var c []TagChange
+		img.Tags, c = r.Tags(img.Tags)
+		if len(c) != 0 {
+			changes[img.Name] = c
+		}
+		out[i] = img
+	}
+	return out, changes
+}
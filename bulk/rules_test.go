@@ -0,0 +1,161 @@
+package bulk_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+const textRules = `
+# aliases
+kancolle -> series:kantai_collection
+shimakaze -> character:shimakaze
+
+# implications
+character:shimakaze => series:kantai_collection shimakaze_cosplay
+shimakaze_cosplay => cosplay
+`
+
+const jsonRules = `{
+  "aliases": {
+    "kancolle": "series:kantai_collection",
+    "shimakaze": "character:shimakaze"
+  },
+  "implications": {
+    "character:shimakaze": ["series:kantai_collection", "shimakaze_cosplay"],
+    "shimakaze_cosplay": ["cosplay"]
+  }
+}`
+
+func TestParseRules(t *testing.T) {
+	want := &bulk.Rules{
+		Aliases: map[string]string{
+			"kancolle":  "series:kantai_collection",
+			"shimakaze": "character:shimakaze",
+		},
+		Implications: map[string][]string{
+			"character:shimakaze": {"series:kantai_collection", "shimakaze_cosplay"},
+			"shimakaze_cosplay":   {"cosplay"},
+		},
+	}
+	for _, in := range []string{textRules, jsonRules} {
+		got, err := bulk.ParseRules(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("ParseRules(%q) returned err: %v", in, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("ParseRules(%q) => %#v, want %#v", in, got, want)
+		}
+	}
+}
+
+func TestParseRules_errors(t *testing.T) {
+	tests := []string{
+		"kancolle",
+		"kancolle -> ",
+		"kancolle -> a b",
+		"a b -> c",
+		" => a",
+		"a -> b\na -> c",
+		`{"aliases": {"a": "b c"}}`,
+		`{"aliases": `,
+	}
+	for _, in := range tests {
+		if _, err := bulk.ParseRules(strings.NewReader(in)); err == nil {
+			t.Errorf("ParseRules(%q) expected to return err", in)
+		}
+	}
+}
+
+func TestRules_Tags(t *testing.T) {
+	rules, err := bulk.ParseRules(strings.NewReader(textRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in      []string
+		out     []string
+		changes []bulk.TagChange
+	}{
+		{
+			[]string{"tag1", "tag2"},
+			[]string{"tag1", "tag2"},
+			nil,
+		},
+		{
+			[]string{"kancolle", "tag1"},
+			[]string{"series:kantai_collection", "tag1"},
+			[]bulk.TagChange{{Old: "kancolle", New: "series:kantai_collection"}},
+		},
+		{
+			[]string{"kancolle", "series:kantai_collection"},
+			[]string{"series:kantai_collection"},
+			[]bulk.TagChange{{Old: "kancolle", New: "series:kantai_collection"}},
+		},
+		{
+			[]string{"shimakaze"},
+			[]string{"character:shimakaze", "series:kantai_collection", "shimakaze_cosplay", "cosplay"},
+			[]bulk.TagChange{
+				{Old: "shimakaze", New: "character:shimakaze"},
+				{Old: "character:shimakaze", New: "series:kantai_collection", Implied: true},
+				{Old: "character:shimakaze", New: "shimakaze_cosplay", Implied: true},
+				{Old: "shimakaze_cosplay", New: "cosplay", Implied: true},
+			},
+		},
+		{
+			[]string{"character:shimakaze", "series:kantai_collection", "shimakaze_cosplay", "cosplay"},
+			[]string{"character:shimakaze", "series:kantai_collection", "shimakaze_cosplay", "cosplay"},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		out, changes := rules.Tags(tt.in)
+		if !reflect.DeepEqual(out, tt.out) {
+			t.Errorf("Tags(%q) => %q, want %q", tt.in, out, tt.out)
+		}
+		if !reflect.DeepEqual(changes, tt.changes) {
+			t.Errorf("Tags(%q) changes => %+v, want %+v", tt.in, changes, tt.changes)
+		}
+	}
+}
+
+func TestRules_Tags_aliasCycle(t *testing.T) {
+	rules, err := bulk.ParseRules(strings.NewReader("a -> b\nb -> a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := rules.Tags([]string{"a"})
+	if len(out) != 1 {
+		t.Errorf("Tags with an alias cycle => %q, want a single tag", out)
+	}
+}
+
+func TestRules_Images(t *testing.T) {
+	rules, err := bulk.ParseRules(strings.NewReader(textRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := []bulk.Image{
+		{Name: "a", Tags: []string{"kancolle"}},
+		{Name: "b", Tags: []string{"tag1"}},
+	}
+	got, changes := rules.Images(images)
+	want := []bulk.Image{
+		{Name: "a", Tags: []string{"series:kantai_collection"}},
+		{Name: "b", Tags: []string{"tag1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Images(%v) => %v, want %v", images, got, want)
+	}
+	wantChanges := map[string][]bulk.TagChange{
+		"a": {{Old: "kancolle", New: "series:kantai_collection"}},
+	}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("Images(%v) changes => %+v, want %+v", images, changes, wantChanges)
+	}
+	if images[0].Tags[0] != "kancolle" {
+		t.Errorf("Images modified its input to %v", images)
+	}
+}
@@ -0,0 +1,172 @@
+package bulk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Content reports whether the content of a file agrees with its extension.
+type Content int
+
+const (
+	// ContentUnchecked means the content was not sniffed or the media type
+	// of the extension has no known signature.
+	ContentUnchecked Content = iota
+	// ContentOK means the content matches the extension.
+	ContentOK
+	// ContentMismatch means the content is another known media type.
+	ContentMismatch
+	// ContentNotMedia means the content is not a known media type at all,
+	// for example an HTML error page saved by a downloader.
+	ContentNotMedia
+	// ContentUnreadable means the file could not be read, for example a
+	// symbolic link that leads nowhere.
+	ContentUnreadable
+)
+
+func (c Content) String() string {
+	switch c {
+	case ContentOK:
+		return "ok"
+	case ContentMismatch:
+		return "mismatch"
+	case ContentNotMedia:
+		return "notmedia"
+	case ContentUnreadable:
+		return "unreadable"
+	default:
+		return "unchecked"
+	}
+}
+
+// sniffLen is the number of bytes needed to match every signature.
+const sniffLen = 16
+
+// signatures match the header of a file against the media type with the same
+// name in DefaultMediaTypes.
+var signatures = []struct {
+	name  string
+	match func(b []byte) bool
+}{
+	{"gif", func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("GIF87a")) || bytes.HasPrefix(b, []byte("GIF89a"))
+	}},
+	{"jpeg", func(b []byte) bool { return bytes.HasPrefix(b, []byte{0xFF, 0xD8, 0xFF}) }},
+	{"png", func(b []byte) bool { return bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")) }},
+	{"swf", func(b []byte) bool {
+		return bytes.HasPrefix(b, []byte("FWS")) || bytes.HasPrefix(b, []byte("CWS")) || bytes.HasPrefix(b, []byte("ZWS"))
+	}},
+	{"webm", func(b []byte) bool { return bytes.HasPrefix(b, []byte{0x1A, 0x45, 0xDF, 0xA3}) }},
+	{"webp", func(b []byte) bool {
+		return len(b) >= 12 && bytes.Equal(b[:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WEBP"))
+	}},
+	// AVIF is an ISO base media file like MP4 so it has to be checked first.
+	{"avif", func(b []byte) bool {
+		return len(b) >= 12 && bytes.Equal(b[4:8], []byte("ftyp")) &&
+			(bytes.Equal(b[8:12], []byte("avif")) || bytes.Equal(b[8:12], []byte("avis")))
+	}},
+	{"mp4", func(b []byte) bool { return len(b) >= 8 && bytes.Equal(b[4:8], []byte("ftyp")) }},
+}
+
+// Sniff returns the name of the media type detected from the first bytes of
+// a file or an empty string if none of the known signatures match.
+func Sniff(header []byte) string {
+	for _, s := range signatures {
+		if s.match(header) {
+			return s.name
+		}
+	}
+	return ""
+}
+
+func hasSignature(name string) bool {
+	for _, s := range signatures {
+		if s.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckContent reads the header of each image under dir and sets its Content
+// and Detected fields according to the media type found. Images whose file
+// cannot be read are marked ContentUnreadable.
+func CheckContent(dir string, images []Image) {
+	for i := range images {
+		header, err := readHeader(filepath.Join(dir, filepath.FromSlash(images[i].Name)))
+		if err != nil {
+			images[i].Detected = ""
+			images[i].Content = ContentUnreadable
+			continue
+		}
+		images[i].Detected = Sniff(header)
+		images[i].Content = compareContent(images[i].Name, images[i].Detected)
+	}
+}
+
+func compareContent(name, detected string) Content {
+	t, _ := MediaTypes.Lookup(name)
+	switch {
+	case detected == t.Name:
+		return ContentOK
+	case detected != "":
+		return ContentMismatch
+	case hasSignature(t.Name):
+		return ContentNotMedia
+	default:
+		return ContentUnchecked
+	}
+}
+
+func readHeader(filename string) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// FixExtension renames the file of an image whose content does not match its
+// extension, so that the extension agrees with the detected media type. The
+// Name and Content of img are updated accordingly. It fails if a file with
+// the new name already exists.
+func FixExtension(dir string, img *Image) error {
+	if img.Content != ContentMismatch {
+		return fmt.Errorf("%v: extension already matches content", img.Name)
+	}
+	var ext string
+	for _, t := range MediaTypes.Types() {
+		if t.Name == img.Detected && len(t.Extensions) != 0 {
+			ext = t.Extensions[0]
+			break
+		}
+	}
+	if ext == "" {
+		return fmt.Errorf("%v: detected type %q is not supported", img.Name, img.Detected)
+	}
+
+	newName := strings.TrimSuffix(img.Name, path.Ext(img.Name)) + "." + ext
+	oldPath := filepath.Join(dir, filepath.FromSlash(img.Name))
+	newPath := filepath.Join(dir, filepath.FromSlash(newName))
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("%v: cannot rename, %v already exists", img.Name, newName)
+	}
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	img.Name = newName
+	img.Content = ContentOK
+	return nil
+}
@@ -0,0 +1,118 @@
+package bulk_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+var (
+	pngHeader  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	jpegHeader = []byte{0xFF, 0xD8, 0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F'}
+)
+
+var sniffTests = []struct {
+	in  []byte
+	out string
+}{
+	{nil, ""},
+	{[]byte("<html><body>404</body></html>"), ""},
+	{pngHeader, "png"},
+	{jpegHeader, "jpeg"},
+	{[]byte("GIF89a\x01\x00"), "gif"},
+	{[]byte("CWS\x0a"), "swf"},
+	{[]byte{0x1A, 0x45, 0xDF, 0xA3, 0x9F}, "webm"},
+	{[]byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "webp"},
+	{[]byte("\x00\x00\x00\x1cftypavif"), "avif"},
+	{[]byte("\x00\x00\x00\x18ftypmp42"), "mp4"},
+}
+
+func TestSniff(t *testing.T) {
+	for _, tt := range sniffTests {
+		if got, want := bulk.Sniff(tt.in), tt.out; got != want {
+			t.Errorf("Sniff(%q) => %q, want %q", tt.in, got, want)
+		}
+	}
+}
+
+func TestCheckContent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"ok.png":       pngHeader,
+		"png.jpg":      pngHeader,
+		"error.png":    []byte("<html>"),
+		"empty.gif":    nil,
+		"unknown.mine": []byte("anything"),
+	}
+	var images []bulk.Image
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, bulk.Image{Name: name})
+	}
+
+	// A file that cannot be read does not stop the others from being
+	// checked.
+	images = append(images, bulk.Image{Name: "missing.png"})
+
+	bulk.MediaTypes.Register(bulk.MediaType{Name: "mine", Extensions: []string{"mine"}})
+	defer func() { bulk.MediaTypes = bulk.NewRegistry(bulk.DefaultMediaTypes...) }()
+
+	bulk.CheckContent(dir, images)
+	want := map[string]bulk.Content{
+		"ok.png":       bulk.ContentOK,
+		"png.jpg":      bulk.ContentMismatch,
+		"error.png":    bulk.ContentNotMedia,
+		"empty.gif":    bulk.ContentNotMedia,
+		"unknown.mine": bulk.ContentUnchecked,
+		"missing.png":  bulk.ContentUnreadable,
+	}
+	for _, img := range images {
+		if got := img.Content; got != want[img.Name] {
+			t.Errorf("CheckContent for %q => %v, want %v", img.Name, got, want[img.Name])
+		}
+	}
+}
+
+func TestFixExtension(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.jpg", "b.jpg", "b.png"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pngHeader, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	images := []bulk.Image{{Name: "a.jpg"}, {Name: "b.jpg"}, {Name: "b.png"}}
+	bulk.CheckContent(dir, images)
+
+	if err := bulk.FixExtension(dir, &images[0]); err != nil {
+		t.Fatalf("FixExtension(%q) returned err %v", "a.jpg", err)
+	}
+	if got, want := images[0].Name, "a.png"; got != want {
+		t.Errorf("FixExtension(%q) renamed to %q, want %q", "a.jpg", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.png")); err != nil {
+		t.Errorf("FixExtension(%q) did not rename file on disk: %v", "a.jpg", err)
+	}
+	// Target already exists.
+	if err := bulk.FixExtension(dir, &images[1]); err == nil {
+		t.Errorf("FixExtension(%q) expected to fail when %q exists", "b.jpg", "b.png")
+	}
+	// Extension already matches.
+	if err := bulk.FixExtension(dir, &images[2]); err == nil {
+		t.Errorf("FixExtension(%q) expected to fail for matching extension", "b.png")
+	}
+}
@@ -0,0 +1,118 @@
+package bulk
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Problem is a single problem found in a CSV file.
+type Problem struct {
+	// Line is the line of the record, starting from 1.
+	Line int
+	// Column is the field of the record, starting from 1, or 0 if the
+	// problem concerns the whole record.
+	Column int
+	Reason string
+}
+
+func (p Problem) String() string {
+	if p.Column == 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Reason)
+	}
+	return fmt.Sprintf("line %d, column %d: %s", p.Line, p.Column, p.Reason)
+}
+
+// ValidationError is returned when loading a CSV file that does not have the
+// expected format. It lists every problem found in the file.
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	s := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		s[i] = p.String()
+	}
+	return "invalid csv file format: " + strings.Join(s, "; ")
+}
+
+// validator collects the problems of the records of a CSV file.
+type validator struct {
+	ratings  RatingScheme
+	problems []Problem
+	paths    map[string]seenPath
+}
+
+func (v *validator) add(line, column int, format string, a ...interface{}) {
+	v.problems = append(v.problems, Problem{Line: line, Column: column, Reason: fmt.Sprintf(format, a...)})
+}
+
+// addField adds a problem with the field at position i of a record, whose
+// column is called name. A record without the column has no position so the
+// problem names the column instead.
+func (v *validator) addField(line, i int, name, format string, a ...interface{}) {
+	if i < 0 {
+		v.add(line, 0, "no %s column: %s", name, fmt.Sprintf(format, a...))
+		return
+	}
+	v.add(line, i+1, format, a...)
+}
+
+// validate checks the image read from a record found on line, whose fields
+// are at columns c, and reports whether it can be loaded.
+func (v *validator) validate(line int, c columns, img Image) bool {
+	ok := true
+	for _, t := range img.Tags {
+		if strings.Contains(t, ",") {
+			v.addField(line, c.tags, "tags", "tag %q contains a comma", t)
+			ok = false
+		}
+	}
+	if !v.ratings.Valid(img.Rating) {
+		v.addField(line, c.rating, "rating", "unknown rating %q, expected one of %q", img.Rating, v.ratings.Values())
+		ok = false
+	}
+	return ok
+}
+
+// unique reports whether the image with name, read from the path of a
+// record found on line, was not read before. Paths are compared once they
+// are turned to image names since different paths can name the same image,
+// like files with the same name in different folders when the names are
+// only the filenames.
+func (v *validator) unique(line int, c columns, path, name string) bool {
+	if v.paths == nil {
+		v.paths = make(map[string]seenPath)
+	}
+	if first, dup := v.paths[name]; dup {
+		if path == first.path {
+			v.addField(line, c.path, "path", "duplicate image path %q, first seen on line %d", path, first.line)
+		} else {
+			v.addField(line, c.path, "path", "path %q is image %q again, first seen on line %d", path, name, first.line)
+		}
+		return false
+	}
+	v.paths[name] = seenPath{line: line, path: path}
+	return true
+}
+
+// seenPath is the path of the first record that named an image.
+type seenPath struct {
+	line int
+	path string
+}
+
+// err returns a ValidationError with the problems found or nil if there are
+// none.
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	// Duplicates are found after the other problems of their record.
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		a, b := v.problems[i], v.problems[j]
+		return a.Line < b.Line || (a.Line == b.Line && a.Column < b.Column)
+	})
+	return &ValidationError{Problems: v.problems}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+// commands are the subcommands of tagaa, given as its first argument. Without
+// a subcommand, tagaa starts the web interface.
+var commands = map[string]func(args []string) error{
+	"diff":  diffCommand,
+	"merge": mergeCommand,
+}
+
+const diffUsage = `Usage: %s diff [options] a.csv b.csv
+
+  Lists the images added, removed and changed from a.csv to b.csv. Images are
+  matched by their path below the folder that holds all of them.
+
+Options:
+
+`
+
+func diffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, diffUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	lenientCSV := fs.Bool("lenient", false, "accept CSV files with a header row, missing or extra columns")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff expects two CSV files")
+	}
+	if err := loadCommandConfig(); err != nil {
+		return err
+	}
+	dir, err := serverDir(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	a, err := readCSVFile(fs.Arg(0), dir, *lenientCSV)
+	if err != nil {
+		return err
+	}
+	b, err := readCSVFile(fs.Arg(1), dir, *lenientCSV)
+	if err != nil {
+		return err
+	}
+	printDiff(os.Stdout, bulk.DiffImages(a, b))
+	return nil
+}
+
+// printDiff writes a line for each image in d, starting with '+' for added,
+// '-' for removed and '~' for changed images, followed by a summary.
+func printDiff(w io.Writer, d bulk.Diff) {
+	for _, img := range d.Added {
+		fmt.Fprintf(w, "+ %s\n", img.Name)
+	}
+	for _, img := range d.Removed {
+		fmt.Fprintf(w, "- %s\n", img.Name)
+	}
+	for _, c := range d.Changed {
+		var parts []string
+		for _, f := range c.Fields {
+			switch f {
+			case bulk.FieldTags:
+				added, removed := tagChanges(c.Old.Tags, c.New.Tags)
+				var tags []string
+				for _, t := range added {
+					tags = append(tags, "+"+t)
+				}
+				for _, t := range removed {
+					tags = append(tags, "-"+t)
+				}
+				parts = append(parts, "tags "+strings.Join(tags, " "))
+			default:
+				parts = append(parts, fmt.Sprintf("%s %q -> %q", f, bulk.FieldValue(c.Old, f), bulk.FieldValue(c.New, f)))
+			}
+		}
+		fmt.Fprintf(w, "~ %s: %s\n", c.New.Name, strings.Join(parts, "; "))
+	}
+	fmt.Fprintln(w, d)
+}
+
+// tagChanges returns the tags of new that old does not have and the tags of
+// old that new does not have.
+func tagChanges(old, new []string) (added, removed []string) {
+	in := func(tags []string, t string) bool {
+		for _, tag := range tags {
+			if tag == t {
+				return true
+			}
+		}
+		return false
+	}
+	for _, t := range new {
+		if t != "" && !in(old, t) {
+			added = append(added, t)
+		}
+	}
+	for _, t := range old {
+		if t != "" && !in(new, t) {
+			removed = append(removed, t)
+		}
+	}
+	return added, removed
+}
+
+const mergeUsage = `Usage: %s merge [options] a.csv b.csv
+
+  Merges two copies of a CSV file. The tags of each image are the union of its
+  tags in both files. An empty source or rating is taken from the other file.
+  For each image with a different source or rating in each file, the value to
+  keep is asked, unless the -prefer option is used. Images are matched by their
+  path below the folder that holds all of them and their paths are written as
+  they are in the files.
+
+Options:
+
+`
+
+func mergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, mergeUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	prefer := fs.String("prefer", "", `resolve conflicts with the value of file "a" or "b" instead of asking`)
+	output := fs.String("o", "", "write the result to this file instead of the standard output")
+	lenientCSV := fs.Bool("lenient", false, "accept CSV files with a header row, missing or extra columns")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("merge expects two CSV files")
+	}
+	if *prefer != "" && *prefer != "a" && *prefer != "b" {
+		return fmt.Errorf("unknown -prefer value %q, expected \"a\" or \"b\"", *prefer)
+	}
+	if err := loadCommandConfig(); err != nil {
+		return err
+	}
+	dir, err := serverDir(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	a, err := readCSVFile(fs.Arg(0), dir, *lenientCSV)
+	if err != nil {
+		return err
+	}
+	b, err := readCSVFile(fs.Arg(1), dir, *lenientCSV)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts := bulk.Merge(a, b)
+	in := bufio.NewScanner(os.Stdin)
+	for _, c := range conflicts {
+		value := c.A
+		switch *prefer {
+		case "b":
+			value = c.B
+		case "":
+			if value, err = askResolution(in, os.Stderr, c); err != nil {
+				return err
+			}
+		}
+		bulk.Resolve(merged, c, value)
+	}
+	if len(conflicts) != 0 {
+		log.Printf("Resolved %d conflicts", len(conflicts))
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); cerr != nil {
+				log.Printf("Error: could not close %v: %v\n", *output, cerr)
+			}
+		}()
+		out = f
+	}
+	w := bulk.NewWriter(out)
+	if dir != "" {
+		w.Dir = dir
+		w.Paths = serverPaths(dir)
+	}
+	return w.WriteAll(merged)
+}
+
+// askResolution asks which value of the conflict c to keep, reading the
+// answers from in and writing the questions to w.
+func askResolution(in *bufio.Scanner, w io.Writer, c bulk.Conflict) (string, error) {
+	fmt.Fprintf(w, "%s has a different %s:\n  a: %q\n  b: %q\n", c.Name, c.Field, c.A, c.B)
+	for {
+		fmt.Fprint(w, "Keep [a], [b] or [e]nter another value? ")
+		if !in.Scan() {
+			if err := in.Err(); err != nil {
+				return "", err
+			}
+			return "", fmt.Errorf("no answer for the %s of %s", c.Field, c.Name)
+		}
+		switch strings.TrimSpace(in.Text()) {
+		case "a":
+			return c.A, nil
+		case "b":
+			return c.B, nil
+		case "e":
+			fmt.Fprintf(w, "New %s: ", c.Field)
+			if !in.Scan() {
+				return "", fmt.Errorf("no value for the %s of %s", c.Field, c.Name)
+			}
+			value := strings.TrimSpace(in.Text())
+			if c.Field == bulk.FieldRating && !bulk.Ratings.Valid(value) {
+				fmt.Fprintf(w, "Unknown rating %q, expected one of %q\n", value, bulk.Ratings.Values())
+				continue
+			}
+			return value, nil
+		}
+	}
+}
+
+// loadCommandConfig loads the project configuration of the current directory
+// so that the commands use its ratings and tag categories.
+func loadCommandConfig() error {
+	c, err := loadConfig(".")
+	if err != nil {
+		return err
+	}
+	setProjectConfig(c)
+	bulk.TagCategories = c.Categories
+	bulk.Ratings = c.Ratings
+	return nil
+}
+
+// readCSVFile reads the images of a CSV file. If dir is set, the image names
+// are their server paths relative to dir, as returned by serverDir.
+func readCSVFile(filename, dir string, lenientCSV bool) ([]bulk.Image, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close %v: %v\n", filename, cerr)
+		}
+	}()
+	r := bulk.NewReader(f)
+	r.Lenient = lenientCSV
+	if dir != "" {
+		r.Dir = dir
+		r.Paths = serverPaths(dir)
+	}
+	images, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return images, nil
+}
+
+// serverPaths returns a path rule that maps the server directory dir to
+// itself, so that a Reader names the images by their path below dir and a
+// Writer writes them back under dir with the same separator.
+func serverPaths(dir string) bulk.PathMap {
+	return bulk.PathMap{{Local: dir, Server: dir}}
+}
+
+// serverDir returns the deepest directory that holds all the server paths of
+// the CSV files, written with '\' if the paths use it, or an empty string if
+// they have no directory in common. Records without a directory, like a header
+// row, are skipped.
+func serverDir(filenames ...string) (string, error) {
+	var (
+		common    string
+		found     bool
+		backslash bool
+	)
+	for _, filename := range filenames {
+		err := func() error {
+			f, err := os.Open(filename)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if cerr := f.Close(); cerr != nil {
+					log.Printf("Error: could not close %v: %v\n", filename, cerr)
+				}
+			}()
+			r := csv.NewReader(f)
+			r.FieldsPerRecord = -1
+			for {
+				record, err := r.Read()
+				if err == io.EOF {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("%v: %v", filename, err)
+				}
+				dir, _ := splitServerPath(record[0])
+				if dir == "" {
+					continue
+				}
+				slashDir := path.Clean(strings.Replace(dir, `\`, "/", -1))
+				if !found {
+					common, found = slashDir, true
+					backslash = strings.Contains(dir, `\`) && !strings.Contains(dir, "/")
+					continue
+				}
+				common = commonDir(common, slashDir)
+			}
+		}()
+		if err != nil {
+			return "", err
+		}
+	}
+	if backslash {
+		common = strings.Replace(common, "/", `\`, -1)
+	}
+	return common, nil
+}
+
+// commonDir returns the directory that holds both of the directories a and b,
+// which are separated by '/', or an empty string if there is none.
+func commonDir(a, b string) string {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	n := 0
+	for n < len(as) && n < len(bs) && as[n] == bs[n] {
+		n++
+	}
+	dir := strings.Join(as[:n], "/")
+	// Both are absolute and only have the root in common.
+	if dir == "" && n != 0 {
+		return "/"
+	}
+	return dir
+}
+
+// splitServerPath splits a server path, separated by '/' or '\', before its
+// last element.
+func splitServerPath(p string) (dir, file string) {
+	i := strings.LastIndexAny(p, `/\`)
+	if i < 0 {
+		return "", p
+	}
+	if i == 0 {
+		return p[:1], p[1:]
+	}
+	return p[:i], p[i+1:]
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommonDir(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want string
+	}{
+		{"/srv/pics", "/srv/pics", "/srv/pics"},
+		{"/srv/pics", "/srv/pics/sub", "/srv/pics"},
+		{"/srv/pics/a", "/srv/pics/b", "/srv/pics"},
+		{"/srv", "/home", "/"},
+		{"/", "/srv", "/"},
+		{"C:/srv/pics", "C:/srv", "C:/srv"},
+		{"C:/srv", "D:/srv", ""},
+		{"pics/a", "pics", "pics"},
+	}
+	for _, tt := range tests {
+		if got := commonDir(tt.a, tt.b); got != tt.want {
+			t.Errorf("commonDir(%q, %q) => %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestServerDir(t *testing.T) {
+	tests := []struct {
+		files []string
+		want  string
+	}{
+		{[]string{"/srv/pics/a.jpg,,,s,\n/srv/pics/sub/b.jpg,,,s,\n", "/srv/pics/sub/deep/c.jpg,,,s,\n"}, "/srv/pics"},
+		{[]string{"path,tags\n/srv/pics/sub/b.jpg,\n"}, "/srv/pics/sub"},
+		{[]string{`C:\srv\pics\a.jpg,,,s,` + "\n" + `C:\srv\pics\sub\b.jpg,,,s,` + "\n"}, `C:\srv\pics`},
+		{[]string{"a.jpg,,,s,\n"}, ""},
+	}
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, tt := range tests {
+		var filenames []string
+		for i, content := range tt.files {
+			filename := filepath.Join(dir, fmt.Sprintf("%d.csv", i))
+			if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			filenames = append(filenames, filename)
+		}
+		got, err := serverDir(filenames...)
+		if err != nil {
+			t.Errorf("serverDir(%q) returned err: %v", tt.files, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("serverDir(%q) => %q, want %q", tt.files, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/kusubooru/tagaa/autocomplete"
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+// configFilename is the name of the project configuration file that is read
+// from the working directory.
+const configFilename = ".tagaa.json"
+
+// config is the project configuration. Any setting missing from the file
+// keeps its default value.
+type config struct {
+	// CSV is the name of the CSV file.
+	CSV string `json:"csv"`
+	// Prefix is the server path prefix that replaces the working directory
+	// path in the CSV file. If empty, it is found from the CSV file.
+	Prefix string `json:"prefix"`
+	// UseLinuxSep writes paths with "/" in the CSV file.
+	UseLinuxSep bool `json:"useLinuxSep"`
+	// Lenient reads CSV files in lenient mode.
+	Lenient bool `json:"lenient"`
+	// UploadURL is where the zip file is uploaded.
+	UploadURL string `json:"uploadURL"`
+	// Autocomplete are the boards tag suggestions are taken from.
+	Autocomplete []string `json:"autocomplete"`
+	// Paths map local directories to server directories. When set, they
+	// are used for the paths of the CSV file instead of Prefix.
+	Paths bulk.PathMap `json:"paths"`
+	// Normalize switches the steps of the tag normalization that runs
+	// before saving.
+	Normalize bulk.Normalizer `json:"normalize"`
+	// Rules is the path, relative to the working directory, of a file with
+	// tag aliases and implications that are applied before saving. See
+	// bulk.ParseRules for its format.
+	Rules string `json:"rules"`
+	// Categories define the prefix, sort order and color of each tag
+	// category.
+	Categories bulk.Categories `json:"categories"`
+	// Ratings are the ratings images can have.
+	Ratings bulk.RatingScheme `json:"ratings"`
+	// RatingMap maps the ratings of the CSV file to ratings of Ratings when
+	// the rating scheme changes. It is applied once, when the CSV file is
+	// first loaded with the new scheme.
+	RatingMap map[string]string `json:"ratingMap"`
+	// Backups is the number of previous versions of the CSV file kept in
+	// the backups project folder. Zero disables backups.
+	Backups int `json:"backups"`
+}
+
+func defaultConfig() *config {
+	// The default slices are copied since decoding the configuration file
+	// writes over their elements.
+	return &config{
+		CSV:          defaultCSVFilename,
+		UploadURL:    defaultUploadURL,
+		Autocomplete: autocomplete.Boards(),
+		Normalize:    bulk.DefaultNormalizer,
+		Categories:   append(bulk.Categories(nil), bulk.DefaultCategories...),
+		Ratings:      append(bulk.RatingScheme(nil), bulk.DefaultRatings...),
+		Backups:      10,
+	}
+}
+
+// currentConfig holds the project configuration. The configuration is never
+// changed in place: a changed copy is stored instead so that handlers can
+// read it while the settings are saved.
+var currentConfig atomic.Value
+
+func init() {
+	setProjectConfig(defaultConfig())
+}
+
+// projectConfig returns the project configuration, which must not be
+// changed.
+func projectConfig() *config {
+	return currentConfig.Load().(*config)
+}
+
+// setProjectConfig replaces the project configuration with c.
+func setProjectConfig(c *config) {
+	currentConfig.Store(c)
+}
+
+// loadConfig reads the project configuration from the working directory dir.
+// A missing file results in the default configuration.
+func loadConfig(dir string) (*config, error) {
+	c := defaultConfig()
+	f, err := os.Open(filepath.Join(dir, configFilename))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close config file: %v\n", cerr)
+		}
+	}()
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("could not decode %v: %v", configFilename, err)
+	}
+	if err := c.Categories.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", configFilename, err)
+	}
+	if err := c.Paths.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", configFilename, err)
+	}
+	if err := c.Ratings.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", configFilename, err)
+	}
+	for from, to := range c.RatingMap {
+		if !c.Ratings.Valid(to) {
+			return nil, fmt.Errorf("%v: rating %q is mapped to %q which is not one of the ratings", configFilename, from, to)
+		}
+	}
+	return c, nil
+}
+
+// applyFlags overrides the configuration with the options set on the command
+// line.
+func (c *config) applyFlags() {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "csv":
+			c.CSV = *csvFilename
+		case "uploadurl":
+			c.UploadURL = *uploadURL
+		case "lenient":
+			c.Lenient = *lenient
+		}
+	})
+}
+
+// saveConfig writes the configuration to the working directory dir.
+func saveConfig(dir string, c *config) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode %v: %v", configFilename, err)
+	}
+	return writeFileAtomic(filepath.Join(dir, configFilename), append(b, '\n'))
+}
+
+// savePathSettings saves the prefix and the Linux separator option of m to
+// the configuration file if the posted form changed them from prefix and
+// useLinuxSep, so that the other buttons of the index page keep them after a
+// restart like Save settings does.
+func savePathSettings(m *model, prefix string, useLinuxSep bool) error {
+	if m.Prefix == prefix && m.UseLinuxSep == useLinuxSep {
+		return nil
+	}
+	file, err := loadConfig(m.WorkingDir)
+	if err != nil {
+		return fmt.Errorf("Error: could not load settings: %v", err)
+	}
+	c := *projectConfig()
+	c.Prefix, file.Prefix = m.Prefix, m.Prefix
+	c.UseLinuxSep, file.UseLinuxSep = m.UseLinuxSep, m.UseLinuxSep
+	if err := saveConfig(m.WorkingDir, file); err != nil {
+		return fmt.Errorf("Error: could not save settings: %v", err)
+	}
+	setProjectConfig(&c)
+	m.Config = &c
+	return nil
+}
+
+// loadRules reads the tag rules file of the configuration. It returns nil
+// rules if no file is configured.
+func loadRules(dir string) (*bulk.Rules, error) {
+	if projectConfig().Rules == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(dir, projectConfig().Rules))
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close rules file: %v\n", cerr)
+		}
+	}()
+	return bulk.ParseRules(f)
+}
+
+// settingsHandler saves the settings of the Advanced panel to the project
+// configuration. Like the other buttons of the index page form, the rest of
+// the form is saved too.
+func settingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+	m, err := updateModel(func(m *model) error {
+		if err := applyForm(m, r.PostForm); err != nil {
+			return fmt.Errorf("Error: could not save: %v", err)
+		}
+
+		// The configuration file is read again so that the options set
+		// on the command line are not saved to it. Only the values that
+		// were changed in the panel override them.
+		file, err := loadConfig(m.WorkingDir)
+		if err != nil {
+			return fmt.Errorf("Error: could not load settings: %v", err)
+		}
+		c := *projectConfig()
+		if m.CSVFilename != c.CSV {
+			c.CSV, file.CSV = m.CSVFilename, m.CSVFilename
+		}
+		if uploadURL := r.PostFormValue("uploadURL"); uploadURL != c.UploadURL {
+			c.UploadURL, file.UploadURL = uploadURL, uploadURL
+		}
+		if _, lenient := r.PostForm["lenientSetting"]; lenient != c.Lenient {
+			c.Lenient, file.Lenient = lenient, lenient
+		}
+		c.Prefix, file.Prefix = m.Prefix, m.Prefix
+		c.UseLinuxSep, file.UseLinuxSep = m.UseLinuxSep, m.UseLinuxSep
+		c.Autocomplete = r.PostForm["autocomplete"]
+		if c.Autocomplete == nil {
+			c.Autocomplete = []string{}
+		}
+		file.Autocomplete = c.Autocomplete
+		if err := saveConfig(m.WorkingDir, file); err != nil {
+			return fmt.Errorf("Error: could not save settings: %v", err)
+		}
+		setProjectConfig(&c)
+		m.Config = &c
+		m.Lenient = c.Lenient
+
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	redirectEdit(w, r, m, "/")
+}
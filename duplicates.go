@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func duplicatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		serveDuplicates(w, r)
+	case "POST":
+		handleDuplicates(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveDuplicates(w http.ResponseWriter, r *http.Request) {
+	m := reloadModel()
+	m.Duplicates = bulk.Duplicates(m.Images)
+	render(w, duplicatesTmpl, m)
+}
+
+// handleDuplicates keeps the image with the posted ID, merges the metadata of
+// its duplicates into it and moves the duplicate files aside.
+func handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PostFormValue("keep"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v is not a valid image ID", r.PostFormValue("keep")), http.StatusBadRequest)
+		return
+	}
+	notFound := false
+	m, err := updateModel(func(m *model) error {
+		keep := bulk.FindByID(m.Images, id)
+		if keep == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", id)
+		}
+		var others []bulk.Image
+		for _, group := range bulk.Duplicates(m.Images) {
+			if group[0].SHA1 != keep.SHA1 {
+				continue
+			}
+			for _, img := range group {
+				if img.ID != keep.ID {
+					others = append(others, img)
+				}
+			}
+		}
+		bulk.MergeInto(keep, others)
+		// The images that were moved aside are saved even if moving the
+		// others failed.
+		moved, err := removeImages(m, others, duplicatesDir)
+		if err != nil {
+			m.Err = fmt.Errorf("Error: could not move duplicate aside: %v", err)
+		}
+		return saveMoved(m, moved, saveToCSVFile)
+	})
+	if notFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		m.Duplicates = bulk.Duplicates(m.Images)
+		renderError(w, duplicatesTmpl, m, err)
+		return
+	}
+	if m.Err != nil {
+		m.Duplicates = bulk.Duplicates(m.Images)
+		render(w, duplicatesTmpl, m)
+		return
+	}
+	http.Redirect(w, r, "/duplicates", http.StatusFound)
+}
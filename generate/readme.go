@@ -0,0 +1,65 @@
+// +build ignore
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+const (
+	readmeFile = "README.md"
+	beginMark  = "<!-- BEGIN SUPPORTED TYPES: generated by go generate; DO NOT EDIT -->"
+	endMark    = "<!-- END SUPPORTED TYPES -->"
+)
+
+var typesTemplate = `| Type | Extensions | MIME | Preview | Upload |
+| ---- | ---------- | ---- | ------- | ------ |
+{{range .}}| {{.Name}} | {{join .Extensions}} | {{.MIME}} | {{.Preview}} | {{if .Uploadable}}yes{{else}}no{{end}} |
+{{end}}`
+
+var typesTmpl = template.Must(template.New("types").Funcs(template.FuncMap{
+	"join": func(exts []string) string {
+		q := make([]string, len(exts))
+		for i := range exts {
+			q[i] = fmt.Sprintf("%q", exts[i])
+		}
+		return strings.Join(q, ", ")
+	},
+}).Parse(typesTemplate))
+
+func main() {
+	if err := generateReadme(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func generateReadme() error {
+	readme, err := ioutil.ReadFile(readmeFile)
+	if err != nil {
+		return err
+	}
+
+	begin := bytes.Index(readme, []byte(beginMark))
+	end := bytes.Index(readme, []byte(endMark))
+	if begin == -1 || end == -1 || end < begin {
+		return fmt.Errorf("could not find supported types marks in %v", readmeFile)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(readme[:begin+len(beginMark)])
+	buf.WriteString("\n")
+	if err := typesTmpl.Execute(&buf, bulk.DefaultMediaTypes); err != nil {
+		return err
+	}
+	buf.Write(readme[end:])
+
+	return ioutil.WriteFile(readmeFile, buf.Bytes(), 0644)
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/safepath"
+)
+
+// journalFilename returns the name of the journal kept next to the CSV file,
+// for example "bulk.journal" for "bulk.csv".
+func journalFilename(csvFilename string) string {
+	return strings.TrimSuffix(csvFilename, filepath.Ext(csvFilename)) + ".journal"
+}
+
+// journalPath returns the path of the journal of the CSV file, which must be
+// inside the working directory dir like the CSV file.
+func journalPath(dir, csvFilename string) (string, error) {
+	p, err := safepath.Resolve(dir, journalFilename(csvFilename))
+	if err != nil {
+		return "", fmt.Errorf("invalid journal file name %q: %v", journalFilename(csvFilename), err)
+	}
+	return p, nil
+}
+
+// loadJournal reads the journal of the CSV file. A missing journal results in
+// an empty one.
+func loadJournal(dir, csvFilename string) (*bulk.Journal, error) {
+	p, err := journalPath(dir, csvFilename)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return &bulk.Journal{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close journal: %v\n", cerr)
+		}
+	}()
+	return bulk.ReadJournal(f)
+}
+
+// appendJournal adds rec to the journal of the CSV file and flushes it to
+// disk.
+func appendJournal(dir, csvFilename string, j *bulk.Journal, rec bulk.Record) (err error) {
+	p, err := journalPath(dir, csvFilename)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	// A partial record left by a crash is ignored when reading as long as
+	// the next record starts on its own line.
+	if info, err := f.Stat(); err == nil && info.Size() != 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			if _, err := io.WriteString(f, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err = j.Add(f, rec); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// journalChanges records in the journal the changes from the images of the
+// CSV file content old to the model images.
+func journalChanges(m *model, old []byte) error {
+	var oldImages []bulk.Image
+	if len(old) != 0 {
+		var err error
+		if oldImages, err = loadCSV(bytes.NewReader(old), m.WorkingDir, m.lenientCSV()); err != nil {
+			return fmt.Errorf("could not read previous CSV file: %v", err)
+		}
+	}
+	changes := bulk.FieldChanges(oldImages, m.Images)
+	if len(changes) == 0 {
+		return nil
+	}
+	j, err := loadJournal(m.WorkingDir, m.CSVFilename)
+	if err != nil {
+		return err
+	}
+	return appendJournal(m.WorkingDir, m.CSVFilename, j, bulk.Record{Op: bulk.OpEdit, Changes: changes})
+}
+
+// loadHistory keeps in the model the history of each image and whether there
+// is an edit to undo or redo.
+func loadHistory(m *model) error {
+	j, err := loadJournal(m.WorkingDir, m.CSVFilename)
+	if err != nil {
+		return err
+	}
+	m.History = make(map[string][]bulk.HistoryEntry)
+	for _, img := range m.Images {
+		if h := j.History(img.Name); len(h) != 0 {
+			m.History[img.Name] = h
+		}
+	}
+	_, m.CanUndo = j.Undo()
+	_, m.CanRedo = j.Redo()
+	return nil
+}
+
+func undoHandler(w http.ResponseWriter, r *http.Request) {
+	undoRedo(w, r, bulk.OpUndo)
+}
+
+func redoHandler(w http.ResponseWriter, r *http.Request) {
+	undoRedo(w, r, bulk.OpRedo)
+}
+
+// undoRedo reverts the last edit or makes again the last undone one and
+// records it in the journal.
+func undoRedo(w http.ResponseWriter, r *http.Request, op bulk.Op) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	m, err := updateModel(func(m *model) error {
+		j, err := loadJournal(m.WorkingDir, m.CSVFilename)
+		if err != nil {
+			return fmt.Errorf("Error: could not load journal: %v", err)
+		}
+		var (
+			rec bulk.Record
+			ok  bool
+		)
+		if op == bulk.OpUndo {
+			rec, ok = j.Undo()
+			rec.Changes = bulk.ReverseChanges(rec.Changes)
+		} else {
+			rec, ok = j.Redo()
+		}
+		if !ok {
+			return nil
+		}
+		bulk.ApplyChanges(m.Images, rec.Changes)
+
+		if err := writeToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		if err := appendJournal(m.WorkingDir, m.CSVFilename, j, bulk.Record{Op: op, Edit: rec.Edit}); err != nil {
+			return fmt.Errorf("Error: could not write journal: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// rollbackHandler sets a field of an image back to the value it had before a
+// change of its history. The posted rollback value is the image ID and the
+// index of the change in the history separated by a colon. Like the other
+// buttons of the index page form, the rest of the form is saved too.
+func rollbackHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+
+	value := r.PostFormValue("rollback")
+	i := strings.Index(value, ":")
+	if i == -1 {
+		http.Error(w, fmt.Sprintf("%v is not a valid change", value), http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(value[:i])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v is not a valid image ID", value[:i]), http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.Atoi(value[i+1:])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v is not a valid change", value), http.StatusBadRequest)
+		return
+	}
+
+	notFound := false
+	m, err := updateModel(func(m *model) error {
+		prefix, useLinuxSep := m.Prefix, m.UseLinuxSep
+		if err := applyForm(m, r.PostForm); err != nil {
+			return fmt.Errorf("Error: could not save: %v", err)
+		}
+		img := bulk.FindByID(m.Images, id)
+		if img == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", id)
+		}
+		j, err := loadJournal(m.WorkingDir, m.CSVFilename)
+		if err != nil {
+			return fmt.Errorf("Error: could not load journal: %v", err)
+		}
+		history := j.History(img.Name)
+		if n < 0 || n >= len(history) {
+			notFound = true
+			return fmt.Errorf("no change %d in the history of %v", n, img.Name)
+		}
+		bulk.SetField(img, history[n].Field, history[n].Old)
+
+		if err := savePathSettings(m, prefix, useLinuxSep); err != nil {
+			return err
+		}
+
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		return nil
+	})
+	if notFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	redirectEdit(w, r, m, fmt.Sprintf("/#tags%d", id))
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/safepath"
+)
+
+// baseFilename is the project file that keeps the CSV file as it was saved
+// after the last load. It is the base of the three-way merge of the next
+// load.
+const baseFilename = "base.csv"
+
+// importPreview is a CSV file loaded through /load whose changes are shown
+// before they are saved. It is also used to reconcile the images with the CSV
+// file when it was changed by another program.
+type importPreview struct {
+	// Token identifies the preview in the form that applies it.
+	Token    string
+	Filename string
+	// Reconcile is set when the file is the CSV file changed by another
+	// program and SHA1 is then its hash when it was previewed.
+	Reconcile bool
+	SHA1      string
+	Strategy  bulk.Strategy
+	Lenient   bool
+	// Prefix is the prefix found in the loaded file, if there are no path
+	// rules.
+	Prefix string
+	// Header is the header row of the loaded file, if it has one.
+	Header []string
+	// HasBase reports whether there was a base for the three-way merge.
+	HasBase bool
+	// Unmatched is the number of images of the file that were not found.
+	Unmatched int
+	// Diff are the changes from the current images to Images.
+	Diff      bulk.Diff
+	Conflicts []bulk.Conflict
+	// Local are the images when the file was loaded and Images the images
+	// with the metadata of the file combined with Strategy.
+	Local  []bulk.Image
+	Images []bulk.Image
+}
+
+// maxPendingImports is the number of previews kept until they are applied.
+// Older previews are dropped so that abandoned ones do not pile up.
+const maxPendingImports = 8
+
+// pendingImports are the CSV files being previewed, oldest first. They are
+// guarded by pendingMu.
+var (
+	pendingMu      sync.Mutex
+	pendingImports []*importPreview
+)
+
+// loadHandler combines the posted CSV file with the images using the posted
+// strategy and shows a preview of the changes. Nothing is saved until the
+// preview is applied.
+func loadHandler(w http.ResponseWriter, r *http.Request) {
+	f, h, err := r.FormFile("csvFilename")
+	if err != nil {
+		m := currentModel()
+		m.Err = fmt.Errorf("Error: could not parse multipart file: %v", err)
+		render(w, indexTmpl, m)
+		return
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close multipart file: %v\n", cerr)
+		}
+	}()
+
+	m := reloadModel()
+	if m.Err != nil {
+		render(w, indexTmpl, m)
+		return
+	}
+	if _, err := safepath.CSV(m.WorkingDir, h.Filename); err != nil {
+		m.Err = fmt.Errorf("Error: invalid CSV file name %q: %v", h.Filename, err)
+		render(w, indexTmpl, m)
+		return
+	}
+
+	strategy := bulk.Strategy(r.FormValue("strategy"))
+	if strategy == "" {
+		strategy = bulk.StrategyOverwrite
+	}
+	lenient := r.FormValue("lenient") != ""
+	base, err := loadBase(m)
+	if err != nil {
+		m.Err = fmt.Errorf("Error: could not load the base of the three-way merge: %v", err)
+		render(w, indexTmpl, m)
+		return
+	}
+	p, err := previewImport(m, f, strategy, lenient, base)
+	if err != nil {
+		m.Err = fmt.Errorf("Error: could not load image metadata from multipart CSV File: %w", err)
+		render(w, indexTmpl, m)
+		return
+	}
+	p.Filename = h.Filename
+	if err := addPendingImport(p); err != nil {
+		m.Err = fmt.Errorf("Error: could not keep the loaded CSV file: %v", err)
+		render(w, indexTmpl, m)
+		return
+	}
+	m.Import = p
+	render(w, loadTmpl, m)
+}
+
+// addPendingImport keeps p until it is applied and sets its token.
+func addPendingImport(p *importPreview) error {
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+	p.Token = token
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingImports = append(pendingImports, p)
+	if n := len(pendingImports) - maxPendingImports; n > 0 {
+		pendingImports = append([]*importPreview(nil), pendingImports[n:]...)
+	}
+	return nil
+}
+
+// takePendingImport returns the preview with the token and forgets it, or nil
+// if there is no such preview.
+func takePendingImport(token string) *importPreview {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	for i, p := range pendingImports {
+		if validToken(token, p.Token) {
+			pendingImports = append(pendingImports[:i:i], pendingImports[i+1:]...)
+			return p
+		}
+	}
+	return nil
+}
+
+// previewImport combines the metadata of the CSV file with the images of the
+// model without changing them. base is the base of the three-way merge and may
+// be empty.
+func previewImport(m *model, file io.Reader, strategy bulk.Strategy, lenient bool, base []bulk.Image) (*importPreview, error) {
+	p := &importPreview{Strategy: strategy, Lenient: lenient}
+	r := csvReader(file, m.WorkingDir, lenient)
+	imported, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("could not load image info from CSV File: %w", err)
+	}
+	p.Header = r.Header
+	// In recursive mode the prefix is the one the reader found and
+	// stripped from the paths.
+	if len(projectConfig().Paths) == 0 && *recursive {
+		p.Prefix = r.Prefix
+	} else if len(projectConfig().Paths) == 0 && r.FirstPath != "" {
+		p.Prefix = bulk.PathPrefix(m.WorkingDir, r.FirstPath)
+	}
+	matched := bulk.MatchByHash(m.Images, imported, m.Hashes)
+	for _, img := range imported {
+		if img.Name != "" {
+			p.Unmatched++
+		}
+	}
+	p.Unmatched -= len(matched)
+
+	p.HasBase = base != nil
+	p.Local = append([]bulk.Image(nil), m.Images...)
+	p.Images, p.Conflicts, err = bulk.Import(m.Images, matched, base, strategy)
+	if err != nil {
+		return nil, err
+	}
+	p.Diff = bulk.DiffImages(p.Local, p.Images)
+	return p, nil
+}
+
+// applyLoadHandler saves the images of the previewed CSV file with the posted
+// resolution of each conflict.
+func applyLoadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+	p := takePendingImport(r.PostFormValue("import"))
+	if p == nil {
+		m := currentModel()
+		m.Err = fmt.Errorf("Error: there is no loaded CSV file to save; load it again")
+		render(w, indexTmpl, m)
+		return
+	}
+
+	m, err := updateModel(func(m *model) error {
+		if err := m.reload(); err != nil {
+			return fmt.Errorf("Error: could not load from CSV File: %w", err)
+		}
+		if p.Reconcile && m.CSVStamp.SHA1 != p.SHA1 {
+			return fmt.Errorf("Error: the CSV file changed again while it was reconciled; save again")
+		}
+		if !p.Reconcile && !bulk.DiffImages(p.Local, m.Images).Empty() {
+			return fmt.Errorf("Error: the images changed while the loaded CSV file was previewed; load it again")
+		}
+		for i, c := range p.Conflicts {
+			if r.PostFormValue(fmt.Sprintf("conflict[%d]", i)) == "b" {
+				bulk.Resolve(p.Images, c, c.B)
+			}
+		}
+		m.Images = p.Images
+		m.CSVFilename = p.Filename
+		if len(projectConfig().Paths) == 0 {
+			m.Prefix = p.Prefix
+		}
+		if p.Lenient {
+			m.Lenient = true
+		}
+		// The header of a reconciled CSV file is the one it has now.
+		if p.Header != nil || p.Reconcile {
+			m.Header = p.Header
+		}
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save file to disk: %v", err)
+		}
+		// Reconciling does not load a file so the base of the next load
+		// stays as it is.
+		if p.Reconcile {
+			return nil
+		}
+		if err := saveBase(m); err != nil {
+			return fmt.Errorf("Error: could not save the base of the next three-way merge: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		m.Err = err
+		render(w, indexTmpl, m)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+// loadBase reads the base of the three-way merge. It returns nil images if
+// there is no base yet.
+func loadBase(m *model) ([]bulk.Image, error) {
+	data, err := ioutil.ReadFile(projectPath(m.WorkingDir, baseFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return loadCSV(bytes.NewReader(data), m.WorkingDir, m.lenientCSV())
+}
+
+// saveBase keeps the saved CSV file as the base of the next three-way merge.
+func saveBase(m *model) error {
+	data, err := ioutil.ReadFile(filepath.Join(m.WorkingDir, m.CSVFilename))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(m.WorkingDir, projectDir), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(m.WorkingDir, baseFilename), data)
+}
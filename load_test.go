@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestPendingImports(t *testing.T) {
+	var previews []*importPreview
+	for i := 0; i < maxPendingImports+1; i++ {
+		p := &importPreview{}
+		if err := addPendingImport(p); err != nil {
+			t.Fatal(err)
+		}
+		previews = append(previews, p)
+	}
+	if p := takePendingImport(previews[0].Token); p != nil {
+		t.Errorf("takePendingImport returned the oldest preview which should have been dropped")
+	}
+	for _, i := range []int{2, 1, maxPendingImports} {
+		if p := takePendingImport(previews[i].Token); p != previews[i] {
+			t.Errorf("takePendingImport(previews[%d].Token) => %p, want %p", i, p, previews[i])
+		}
+		if p := takePendingImport(previews[i].Token); p != nil {
+			t.Errorf("takePendingImport(previews[%d].Token) twice => %p, want nil", i, p)
+		}
+	}
+	if p := takePendingImport(""); p != nil {
+		t.Errorf("takePendingImport(\"\") => %p, want nil", p)
+	}
+}
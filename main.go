@@ -2,13 +2,17 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
-	"mime/multipart"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,12 +22,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/kusubooru/tagaa/autocomplete"
 	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/safepath"
+	"github.com/kusubooru/tagaa/source"
+	"github.com/kusubooru/tagaa/store"
 )
 
 //go:generate go run generate/templates.go
 //go:generate go run generate/swf.go
 //go:generate go run generate/favicons.go
+//go:generate go run generate/readme.go
 
 var (
 	theVersion = "devel"
@@ -38,6 +47,42 @@ var fns = template.FuncMap{
 		return s[len(s)-1]
 	},
 	"join": strings.Join,
+	"list": func(images ...bulk.Image) []bulk.Image { return images },
+	"problems": func(err error) []bulk.Problem {
+		var verr *bulk.ValidationError
+		if errors.As(err, &verr) {
+			return verr.Problems
+		}
+		return nil
+	},
+	"preview": func(name string) string {
+		t, _ := bulk.MediaTypes.Lookup(name)
+		return t.Preview.String()
+	},
+	"uploadable": func(name string) bool {
+		t, _ := bulk.MediaTypes.Lookup(name)
+		return t.Uploadable
+	},
+	"sourceError": func(s string) error {
+		_, err := source.Normalize(s)
+		return err
+	},
+	"tagChanges": func(old, new []string) [][]string {
+		added, removed := tagChanges(old, new)
+		return [][]string{added, removed}
+	},
+	"categories": func() bulk.Categories { return bulk.TagCategories },
+	"ratings":    func() bulk.RatingScheme { return bulk.Ratings },
+	"boards":     autocomplete.Boards,
+	"csrf":       func() string { return csrfToken },
+	"has": func(list []string, s string) bool {
+		for _, v := range list {
+			if v == s {
+				return true
+			}
+		}
+		return false
+	},
 	"printv": func(version string) string {
 		// If version starts with a digit, add 'v'.
 		if versionRx.Match([]byte(version)) {
@@ -47,14 +92,25 @@ var fns = template.FuncMap{
 	},
 }
 
+// Defaults of the options that can also be set in the project configuration.
+const (
+	defaultCSVFilename = "bulk.csv"
+	defaultUploadURL   = "https://kusubooru.com/suggest/upload"
+)
+
 var (
 	directory   = flag.String("dir", ".", "the directory that contains the images")
-	csvFilename = flag.String("csv", "bulk.csv", "the name of the CSV file")
+	csvFilename = flag.String("csv", defaultCSVFilename, "the name of the CSV file")
+	host        = flag.String("host", "127.0.0.1", "address the server listens on; 0.0.0.0 listens on every network interface")
 	port        = flag.String("port", "8080", "server port")
 	openBrowser = flag.Bool("openbrowser", true, "open browser automatically")
 	version     = flag.Bool("v", false, "print program version")
-	uploadURL   = flag.String("uploadurl", "https://kusubooru.com/suggest/upload", "URL to upload zip file")
+	uploadURL   = flag.String("uploadurl", defaultUploadURL, "URL to upload zip file")
 	noexit      = flag.Bool("noexit", false, "if set to true the program will keep running even if the browser window closes")
+	recursive   = flag.Bool("recursive", false, "also load images from subfolders, keeping their relative paths")
+	distance    = flag.Int("distance", 10, "maximum perceptual hash distance (0-64) of images listed as similar")
+	lenient     = flag.Bool("lenient", false, "accept CSV files with a header row, missing or extra columns")
+	mediaTypes  = flag.String("mediatypes", "", "JSON file that defines the supported media types, replacing the default ones")
 )
 
 const description = `
@@ -62,8 +118,9 @@ const description = `
 
   The program will launch a web interface in a new browser window, which allows
   to add tags, source and rating on each image that is contained in the current
-  directory (or the one specified by the -dir option). Subfolders are ignored.
-  Supported types: "gif", "jpeg", "jpg", "png", "swf"
+  directory (or the one specified by the -dir option). Subfolders are ignored
+  unless the -recursive option is used.
+  Supported types: %s
 
   The web interface allows to save the image metadata in a CSV file as expected
   by the 'Bulk Add CSV' Shimmie2 extension. If a CSV file with the name
@@ -77,26 +134,109 @@ const description = `
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
-	fmt.Fprintln(os.Stderr, description)
+	fmt.Fprintf(os.Stderr, "       %s diff [options] a.csv b.csv\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s merge [options] a.csv b.csv\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, description+"\n", quoteAll(bulk.MediaTypes.Extensions()))
 	fmt.Fprintf(os.Stderr, "Options:\n\n")
 	flag.PrintDefaults()
 	fmt.Fprintf(os.Stderr, "\n")
 }
 
+func quoteAll(s []string) string {
+	q := make([]string, len(s))
+	for i := range s {
+		q[i] = strconv.Quote(s[i])
+	}
+	return strings.Join(q, ", ")
+}
+
+// model is what the templates render: the project state and the results
+// of the request.
 type model struct {
-	Err         error
-	Success     string
-	Prefix      string
-	WorkingDir  string
-	CSVFilename string
-	Images      []bulk.Image
-	Version     string
-	UseLinuxSep bool
+	store.State
+	Err        error
+	Success    string
+	Duplicates [][]bulk.Image
+	Similar    []bulk.Pair
+	Backups    []backup
+	Distance   int
+	Version    string
+	// Config is the project configuration.
+	Config *config
+	// History holds the changes of each image recorded in the journal, by
+	// image name, and CanUndo and CanRedo whether there is an edit to undo
+	// or redo.
+	History map[string][]bulk.HistoryEntry
+	CanUndo bool
+	CanRedo bool
+	// Import is the CSV file being loaded while its changes are previewed.
+	Import *importPreview
+	// Revision is the version of the project state that is rendered. Forms
+	// post it back so that edits made meanwhile can be told apart.
+	Revision int
+	// Conflicts are the posted fields that were not saved since they were
+	// also changed by another save.
+	Conflicts []editConflict
+}
+
+func newModel(s store.Snapshot) *model {
+	return &model{State: s.State, Revision: s.Version, Version: theVersion, Config: projectConfig()}
+}
+
+// modelStore keeps the project state shared by the handlers.
+type modelStore interface {
+	Snapshot() store.Snapshot
+	Version() int
+	At(version int) (store.Snapshot, bool)
+	Update(fn func(s *store.State) error) (store.Snapshot, error)
+}
+
+var projectStore modelStore
+
+// currentModel returns a model of the latest project state.
+func currentModel() *model {
+	return newModel(projectStore.Snapshot())
 }
 
-var globalModel *model
+// updateModel calls fn with a model of the latest project state and stores
+// the state of the model if fn succeeds. The model is returned in any case so
+// that it can be rendered with the error.
+func updateModel(fn func(m *model) error) (*model, error) {
+	var m *model
+	snap, err := projectStore.Update(func(s *store.State) error {
+		// Updates are serialized so the latest version is the one of s.
+		m = newModel(store.Snapshot{Version: projectStore.Version(), State: *s})
+		if err := fn(m); err != nil {
+			return err
+		}
+		*s = m.State
+		return nil
+	})
+	if err != nil {
+		return m, err
+	}
+	m.Revision = snap.Version
+	m.Versions = snap.Versions
+	return m, nil
+}
+
+// reloadModel loads the project state again from the working directory. If
+// that fails, the previous state is returned with the error.
+func reloadModel() *model {
+	m, err := updateModel(func(m *model) error { return m.reload() })
+	if err != nil {
+		m.Err = fmt.Errorf("Error: could not load from CSV File: %w", err)
+	}
+	return m
+}
 
 func main() {
+	run := run
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			run = func() error { return cmd(os.Args[2:]) }
+		}
+	}
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -125,39 +265,77 @@ func run() error {
 		return nil
 	}
 
+	if *mediaTypes != "" {
+		r, err := loadMediaTypes(*mediaTypes)
+		if err != nil {
+			return err
+		}
+		bulk.MediaTypes = r
+	}
+
 	d, err := filepath.Abs(*directory)
 	if err != nil {
 		return err
 	}
 	*directory = d
 
+	c, err := loadConfig(*directory)
+	if err != nil {
+		return err
+	}
+	c.applyFlags()
+	setProjectConfig(c)
+	bulk.TagCategories = c.Categories
+	bulk.Ratings = c.Ratings
+
 	// If CSV File does not exist, we create it.
-	csvFile := filepath.Join(*directory, *csvFilename)
+	csvFile, err := safepath.CSV(*directory, c.CSV)
+	if err != nil {
+		return fmt.Errorf("invalid CSV file name %q: %v", c.CSV, err)
+	}
 	if _, err = os.Stat(csvFile); os.IsNotExist(err) {
 		if err = createFile(csvFile); err != nil {
 			return err
 		}
 	}
 
-	m, err := loadFromCSVFile(*directory, *csvFilename)
-	if err != nil {
+	m := newModel(store.Snapshot{State: store.State{
+		WorkingDir:  *directory,
+		CSVFilename: c.CSV,
+		UseLinuxSep: c.UseLinuxSep,
+		Lenient:     c.Lenient,
+	}})
+	if err := m.reload(); err != nil {
 		return err
 	}
-	globalModel = m
+	projectStore = store.New(m.State)
 
+	if err := newSession(); err != nil {
+		return fmt.Errorf("could not create session: %v", err)
+	}
 	http.Handle("/", http.HandlerFunc(indexHandler))
 	http.Handle("/load", http.HandlerFunc(loadHandler))
+	http.Handle("/load/apply", http.HandlerFunc(applyLoadHandler))
 	http.Handle("/update", http.HandlerFunc(updateHandler))
+	http.Handle("/fixext", http.HandlerFunc(fixExtHandler))
 	http.Handle("/ok/", http.HandlerFunc(okHandler))
 	http.Handle("/img/", http.HandlerFunc(serveImage))
 	http.Handle("/upload", http.HandlerFunc(uploadHandler))
+	http.Handle("/duplicates", http.HandlerFunc(duplicatesHandler))
+	http.Handle("/similar", http.HandlerFunc(similarHandler))
+	http.Handle("/backups", http.HandlerFunc(backupsHandler))
+	http.Handle("/undo", http.HandlerFunc(undoHandler))
+	http.Handle("/redo", http.HandlerFunc(redoHandler))
+	http.Handle("/rollback", http.HandlerFunc(rollbackHandler))
 	http.Handle("/tags", http.HandlerFunc(tagsHandler))
+	http.Handle("/settings", http.HandlerFunc(settingsHandler))
+	http.Handle("/sources", http.HandlerFunc(sourcesHandler))
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("web/static"))))
 	http.Handle("/exit", http.HandlerFunc(exitHandler))
 
 	go func() {
-		localURL := fmt.Sprintf("http://localhost:%v", *port)
-		okURL := fmt.Sprintf("%v/ok", localURL)
+		localURL := fmt.Sprintf("http://%v/?%v=%v", net.JoinHostPort(browserHost(*host), *port), tokenParam, sessionToken)
+		okURL := fmt.Sprintf("http://%v/ok", net.JoinHostPort(browserHost(*host), *port))
 		if waitServer(okURL) && *openBrowser && startBrowser(localURL) {
 			log.Printf("A browser window should open. If not, please visit %s", localURL)
 		} else {
@@ -165,97 +343,167 @@ func run() error {
 		}
 	}()
 
-	return http.ListenAndServe(":"+*port, nil)
+	return http.ListenAndServe(net.JoinHostPort(*host, *port), guard(http.DefaultServeMux))
 }
 
-func loadFromCSVFile(dir, csvFilename string) (*model, error) {
-
-	m := &model{WorkingDir: dir, CSVFilename: csvFilename, Version: theVersion}
-	if globalModel != nil {
-		m.UseLinuxSep = globalModel.UseLinuxSep
+func loadMediaTypes(filename string) (*bulk.Registry, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close media types file: %v\n", cerr)
+		}
+	}()
+	return bulk.LoadRegistry(f)
+}
+
+// reload loads the images of the working directory and their metadata from
+// the CSV file into the model. The settings of the model are kept. If
+// reloading fails, the model is not changed.
+func (m *model) reload() error {
+	dir := m.WorkingDir
+	next := *m
+	next.Prefix = ""
 
 	// Loading images from folder
-	files, err := ioutil.ReadDir(dir)
+	images, err := loadImages(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	images := bulk.LoadImages(files)
-
-	f, err := os.Open(filepath.Join(dir, csvFilename))
+	bulk.CheckContent(dir, images)
+	if next.Hashes, err = loadHashes(dir); err != nil {
+		return err
+	}
+	// The hashes are saved as soon as they are computed so that they are
+	// not computed again on each page load until the CSV file is saved.
+	if bulk.HashImages(dir, images, next.Hashes) {
+		if err = saveHashCache(dir, next.Hashes); err != nil {
+			return err
+		}
+	}
+	// Perceptual hashes are looked up by SHA1 so they are computed once the
+	// hashes are known. Decoding is slow so they are cached too.
+	phashes, err := loadPHashes(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer func() {
-		if cerr := f.Close(); cerr != nil && err == nil {
-			err = cerr
+	if bulk.PerceptualHashImages(dir, images, phashes) {
+		if err = savePHashes(dir, phashes); err != nil {
+			return err
 		}
-	}()
+	}
+	if err = assignIDs(dir, images); err != nil {
+		return err
+	}
 
-	// Loading CSV image data
-	imagesWithInfo, err := bulk.LoadCSV(f)
+	// The file is read at once and stamped so that changes made to it by
+	// other programs can be detected before it is saved.
+	filename := filepath.Join(dir, m.CSVFilename)
+	info, err := os.Stat(filename)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
 	}
-	m.Images = bulk.Combine(images, imagesWithInfo)
+	next.CSVStamp = stampCSVFile(m, info, data)
 
-	// Getting current prefix
-	if _, err = f.Seek(0, 0); err != nil {
-		return nil, err
+	// Loading CSV image data. If the rating scheme changed since the file
+	// was saved, it is read with the previous scheme and migrated below.
+	ratings, err := loadRatings(dir)
+	if err != nil {
+		return err
 	}
-	cp, err := bulk.CurrentPrefix(dir, f)
+	// A file saved with a header row or extra columns is read leniently
+	// even if the option is not set.
+	columns, err := hasColumns(dir)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	m.Prefix = cp
-
-	return m, nil
-}
-
-func loadHandler(w http.ResponseWriter, r *http.Request) {
-	f, h, err := r.FormFile("csvFilename")
+	r := csvReader(bytes.NewReader(data), dir, m.lenientCSV() || columns)
+	r.Ratings = ratings
+	imagesWithInfo, err := r.ReadAll()
 	if err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not parse multipart file: %v", err)
-		render(w, indexTmpl, globalModel)
-		return
+		return err
 	}
-	defer func() {
-		if cerr := f.Close(); cerr != nil {
-			log.Printf("Error: could not close multipart file: %v\n", cerr)
-		}
-	}()
+	next.Header = r.Header
+	next.Images = bulk.CombineByHash(images, imagesWithInfo, next.Hashes)
 
-	if err = addFromMultipartFile(globalModel, f); err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not load image metadata from multipart CSV File: %v", err)
-		render(w, indexTmpl, globalModel)
-		return
+	// Getting current prefix
+	// The prefix is only used when there are no path rules. In recursive
+	// mode it is the one the reader found and stripped from the paths.
+	if len(projectConfig().Paths) == 0 {
+		cp := r.Prefix
+		if !*recursive && r.FirstPath != "" {
+			cp = bulk.PathPrefix(dir, r.FirstPath)
+		}
+		next.Prefix = cp
+		if projectConfig().Prefix != "" {
+			next.Prefix = projectConfig().Prefix
+		}
 	}
-	globalModel.CSVFilename = h.Filename
 
-	err = saveToCSVFile(globalModel)
-	if err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not save file to disk: %v", err)
-		render(w, indexTmpl, globalModel)
-		return
+	if !ratings.Equal(bulk.Ratings) {
+		if err := migrateRatings(&next, ratings); err != nil {
+			return fmt.Errorf("could not change rating scheme: %v", err)
+		}
 	}
-	http.Redirect(w, r, "/", http.StatusFound)
+	*m = next
+	return nil
 }
-func addFromMultipartFile(m *model, file multipart.File) error {
-	imgMetadata, err := bulk.LoadCSV(file)
-	if err != nil {
-		return fmt.Errorf("could not load image info from CSV File: %v", err)
+
+// extraColumns reports whether the CSV file of m has a header row or columns
+// besides the five of the strict format.
+func (m *model) extraColumns() bool {
+	if m.Header != nil {
+		return true
 	}
-	if _, err = file.Seek(0, 0); err != nil {
-		return fmt.Errorf("could not seek multipart file: %v", err)
+	for _, img := range m.Images {
+		if len(img.Extra) != 0 {
+			return true
+		}
 	}
-	prefix, err := bulk.CurrentPrefix(m.WorkingDir, file)
+	return false
+}
+
+// lenientCSV reports whether the CSV files of m are read in lenient mode,
+// because of the option or because the CSV file has extra columns.
+func (m *model) lenientCSV() bool {
+	return m.Lenient || m.extraColumns()
+}
+
+// loadImages discovers the images under dir, walking its subfolders too if
+// the recursive option is set.
+func loadImages(dir string) ([]bulk.Image, error) {
+	if *recursive {
+		return bulk.WalkImages(dir)
+	}
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return fmt.Errorf("could not read current prefix from multipart file: %v", err)
+		return nil, err
 	}
-	m.Prefix = prefix
-	m.Images = bulk.Combine(m.Images, imgMetadata)
+	return bulk.LoadImages(files), nil
+}
 
-	return nil
+// loadCSV loads the image metadata from a CSV file, keeping the image paths
+// relative to dir if the recursive option is set. In lenient mode, CSV files
+// written by other tools are accepted as well.
+func loadCSV(file io.Reader, dir string, lenient bool) ([]bulk.Image, error) {
+	return csvReader(file, dir, lenient).ReadAll()
+}
+
+// csvReader returns the reader used by loadCSV.
+func csvReader(file io.Reader, dir string, lenient bool) *bulk.Reader {
+	r := bulk.NewReader(file)
+	if *recursive {
+		r.Dir = dir
+	}
+	r.Lenient = lenient
+	r.Paths = projectConfig().Paths
+	return r
 }
 
 func okHandler(w http.ResponseWriter, r *http.Request) {
@@ -265,14 +513,12 @@ func okHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
-	m, err := loadFromCSVFile(globalModel.WorkingDir, globalModel.CSVFilename)
-	if err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not load from CSV File: %v", err)
-	} else {
-		globalModel = m
+	m := reloadModel()
+	if err := loadHistory(m); err != nil {
+		m.Err = fmt.Errorf("Error: could not load journal: %v", err)
 	}
 
-	render(w, indexTmpl, globalModel)
+	render(w, indexTmpl, m)
 }
 
 func render(w http.ResponseWriter, t *template.Template, model interface{}) {
@@ -289,54 +535,363 @@ func updateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	m, err := updateModel(func(m *model) error {
+		sources := make(map[int]string, len(m.Images))
+		for _, img := range m.Images {
+			sources[img.ID] = img.Source
+		}
+		prefix, useLinuxSep := m.Prefix, m.UseLinuxSep
+		if err := applyForm(m, r.PostForm); err != nil {
+			return fmt.Errorf("Error: could not save: %v", err)
+		}
+		// Only the edited sources are normalized so that sources imported
+		// from a CSV file stay as they are until they are normalized on
+		// purpose.
+		for i, img := range m.Images {
+			if img.Source != sources[img.ID] {
+				m.Images[i].Source, _ = source.Normalize(img.Source)
+			}
+		}
+		if err := savePathSettings(m, prefix, useLinuxSep); err != nil {
+			return err
+		}
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	// scroll
+	scroll := r.PostForm["scroll"][0]
+	redirectEdit(w, r, m, "/"+scroll)
+}
+
+// sourcesHandler saves the posted form after normalizing the sources of all
+// the images.
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+	m, err := updateModel(func(m *model) error {
+		prefix, useLinuxSep := m.Prefix, m.UseLinuxSep
+		if err := applyForm(m, r.PostForm); err != nil {
+			return fmt.Errorf("Error: could not save: %v", err)
+		}
+		for i, img := range m.Images {
+			m.Images[i].Source, _ = source.Normalize(img.Source)
+		}
+		if err := savePathSettings(m, prefix, useLinuxSep); err != nil {
+			return err
+		}
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	redirectEdit(w, r, m, "/")
+}
+
+// redirectEdit redirects to url after the index page form was saved, unless
+// some of the posted fields were not saved because of conflicts, which are
+// shown instead.
+func redirectEdit(w http.ResponseWriter, r *http.Request, m *model, url string) {
+	if len(m.Conflicts) != 0 {
+		render(w, conflictsTmpl, m)
+		return
+	}
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+// errStaleForm is returned by applyForm when the posted form was made for
+// files that changed since.
+var errStaleForm = errors.New("the page is out of date since images were added, removed or changed; reload it and make the changes again")
+
+// postedNameRx matches the names of the hidden inputs that hold the image
+// name of each posted ID.
+var postedNameRx = regexp.MustCompile(`^image\[(\d+)\]\.name$`)
+
+// checkForm reports errStaleForm if an image ID of the posted form no longer
+// refers to the file it referred to when the page was rendered.
+func checkForm(m *model, form url.Values) error {
+	names := make(map[int]string, len(m.Images))
+	for _, img := range m.Images {
+		names[img.ID] = img.Name
+	}
+	for key, values := range form {
+		match := postedNameRx.FindStringSubmatch(key)
+		if match == nil || len(values) == 0 {
+			continue
+		}
+		id, err := strconv.Atoi(match[1])
+		if err != nil {
+			return errStaleForm
+		}
+		if name, ok := names[id]; !ok || name != values[0] {
+			return errStaleForm
+		}
+	}
+	return nil
+}
+
+// editConflict is a field of an image that was changed both in a posted form
+// and, since the form was rendered, by another save. A is the saved value and
+// B the posted one.
+type editConflict struct {
+	ID int
+	bulk.Conflict
+}
+
+// applyForm copies the values posted by the index page form to the model. It
+// changes nothing if checkForm fails.
+//
+// If an image changed since the revision the form was rendered from, only
+// the fields changed in the form are copied. The fields that were changed on
+// both sides are left as they are and kept in the model conflicts.
+func applyForm(m *model, form url.Values) error {
+	if err := checkForm(m, form); err != nil {
+		return err
+	}
+	base, err := formBase(form)
+	if err != nil {
+		return err
+	}
 	// prefix
-	globalModel.Prefix = r.PostForm["prefix"][0]
+	m.Prefix = form["prefix"][0]
 	// csvFilename
-	globalModel.CSVFilename = r.PostForm["csvFilename"][0]
-	for _, img := range globalModel.Images {
-		// tags
-		areaTags := r.PostForm[fmt.Sprintf("image[%d].tags", img.ID)]
-		globalModel.Images[img.ID].Tags = strings.Fields(areaTags[0])
-		// source
-		globalModel.Images[img.ID].Source = r.PostForm[fmt.Sprintf("image[%d].source", img.ID)][0]
-		// rating
-		rating := r.PostForm[fmt.Sprintf("image[%d].rating", img.ID)]
-		if len(rating) != 0 {
-			globalModel.Images[img.ID].Rating = rating[0]
+	if _, err := safepath.CSV(m.WorkingDir, form["csvFilename"][0]); err != nil {
+		return fmt.Errorf("invalid CSV file name %q: %v", form["csvFilename"][0], err)
+	}
+	m.CSVFilename = form["csvFilename"][0]
+	for i, img := range m.Images {
+		version := form[fmt.Sprintf("image[%d].version", img.ID)]
+		stale := base != nil && len(version) != 0 && version[0] != strconv.Itoa(m.Versions[img.Name])
+		for _, f := range []bulk.Field{bulk.FieldTags, bulk.FieldSource, bulk.FieldRating} {
+			values := form[fmt.Sprintf("image[%d].%s", img.ID, f)]
+			if len(values) == 0 {
+				continue
+			}
+			if !stale {
+				setFormField(&m.Images[i], f, values[0])
+				continue
+			}
+			var posted bulk.Image
+			setFormField(&posted, f, values[0])
+			value := bulk.FieldValue(posted, f)
+			old, ok := base[img.Name]
+			if (ok && value == bulk.FieldValue(old, f)) || value == bulk.FieldValue(img, f) {
+				continue
+			}
+			if !ok || bulk.FieldValue(old, f) != bulk.FieldValue(img, f) {
+				m.Conflicts = append(m.Conflicts, editConflict{ID: img.ID, Conflict: bulk.Conflict{
+					Name:  img.Name,
+					Field: f,
+					A:     bulk.FieldValue(img, f),
+					B:     value,
+				}})
+				continue
+			}
+			setFormField(&m.Images[i], f, values[0])
 		}
 	}
 	// UseLinuxSep
-	_, ok := r.PostForm["useLinuxSep"]
+	_, ok := form["useLinuxSep"]
 	if ok {
-		globalModel.UseLinuxSep = true
+		m.UseLinuxSep = true
 	} else {
-		globalModel.UseLinuxSep = false
+		m.UseLinuxSep = false
 	}
-	// scroll
-	scroll := r.PostForm["scroll"][0]
+	return nil
+}
 
-	if err := saveToCSVFile(globalModel); err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not save to CSV file: %v", err)
-		render(w, indexTmpl, globalModel)
-	} else {
-		globalModel.Err = nil
-		http.Redirect(w, r, "/"+scroll, http.StatusFound)
+// setFormField sets a field of img from the value posted by the index page
+// form. Tags are split the way the normalizer of the project expects.
+func setFormField(img *bulk.Image, f bulk.Field, value string) {
+	if f == bulk.FieldTags {
+		img.Tags = projectConfig().Normalize.SplitTags(value)
+		return
 	}
+	bulk.SetField(img, f, value)
 }
 
-func saveToCSVFile(m *model) error {
-	csvFilepath := filepath.Join(m.WorkingDir, m.CSVFilename)
-	f, err := os.Create(csvFilepath)
+// formBase returns the images of the revision the posted form was rendered
+// from by name. It returns nil if the form has no revision. If the revision is
+// too old to be kept, it returns an empty map so that every field changed on
+// both sides is a conflict.
+func formBase(form url.Values) (map[string]bulk.Image, error) {
+	revision := form.Get("revision")
+	if revision == "" {
+		return nil, nil
+	}
+	v, err := strconv.Atoi(revision)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("%v is not a valid revision", revision)
 	}
-	defer func() {
-		if cerr := f.Close(); err != nil {
-			err = cerr
+	base := make(map[string]bulk.Image)
+	snap, ok := projectStore.At(v)
+	if !ok {
+		return base, nil
+	}
+	for _, img := range snap.Images {
+		base[img.Name] = img
+	}
+	return base, nil
+}
+
+// fixExtHandler saves the posted form and then renames the image with the ID
+// given by the fixext value, so that its extension matches its content.
+func fixExtHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+	id, err := strconv.Atoi(r.PostFormValue("fixext"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v is not a valid image ID", r.PostFormValue("fixext")), http.StatusBadRequest)
+		return
+	}
+
+	notFound := false
+	m, err := updateModel(func(m *model) error {
+		prefix, useLinuxSep := m.Prefix, m.UseLinuxSep
+		if err := applyForm(m, r.PostForm); err != nil {
+			return fmt.Errorf("Error: could not save: %v", err)
 		}
-	}()
+		img := bulk.FindByID(m.Images, id)
+		if img == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", id)
+		}
+		if err := savePathSettings(m, prefix, useLinuxSep); err != nil {
+			return err
+		}
+		// The form is saved before renaming so that its changes are
+		// journaled under the old name, which the rename record then moves.
+		if err := saveToCSVFile(m); err != nil {
+			return fmt.Errorf("Error: could not save to CSV file: %w", err)
+		}
+		// Saving replaces the images so the image is looked up again.
+		if img = bulk.FindByID(m.Images, id); img == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", id)
+		}
+		oldName := img.Name
+		if err := bulk.FixExtension(m.WorkingDir, img); err != nil {
+			return fmt.Errorf("Error: could not fix extension: %v", err)
+		}
+		moved := moves{{
+			from: filepath.Join(m.WorkingDir, filepath.FromSlash(oldName)),
+			to:   filepath.Join(m.WorkingDir, filepath.FromSlash(img.Name)),
+		}}
+		if err := saveMoved(m, moved, writeToCSVFile); err != nil {
+			return err
+		}
+		j, err := loadJournal(m.WorkingDir, m.CSVFilename)
+		if err != nil {
+			return fmt.Errorf("Error: could not load journal: %v", err)
+		}
+		rec := bulk.Record{Op: bulk.OpRename, From: oldName, To: img.Name}
+		if err := appendJournal(m.WorkingDir, m.CSVFilename, j, rec); err != nil {
+			return fmt.Errorf("Error: could not write journal: %v", err)
+		}
+		return nil
+	})
+	if notFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		renderError(w, indexTmpl, m, err)
+		return
+	}
+	redirectEdit(w, r, m, fmt.Sprintf("/#tags%d", id))
+}
+
+// saveToCSVFile writes the model images to the CSV file and records the
+// changes in the journal.
+func saveToCSVFile(m *model) error {
+	old, err := ioutil.ReadFile(filepath.Join(m.WorkingDir, m.CSVFilename))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := writeToCSVFile(m); err != nil {
+		return err
+	}
+	if err := journalChanges(m, old); err != nil {
+		return fmt.Errorf("could not write journal: %v", err)
+	}
+	return nil
+}
+
+// csvWriter returns a CSV writer with the path settings of the model.
+func (m *model) csvWriter(w io.Writer) *bulk.Writer {
+	cw := bulk.NewWriter(w)
+	cw.Dir = m.WorkingDir
+	cw.Paths = projectConfig().Paths
+	cw.Prefix = m.Prefix
+	cw.UseLinuxSep = m.UseLinuxSep
+	cw.Header = m.Header
+	return cw
+}
 
-	return bulk.Save(f, m.Images, m.WorkingDir, m.Prefix, m.UseLinuxSep)
+// ServerPath returns the path of img written in the CSV file or an empty
+// string if no path rule matches it.
+func (m *model) ServerPath(img bulk.Image) string {
+	p, err := m.csvWriter(nil).ServerPath(img)
+	if err != nil {
+		return ""
+	}
+	return p
+}
+
+// writeToCSVFile writes the model images to the CSV file without recording
+// the changes in the journal.
+func writeToCSVFile(m *model) error {
+	changed, err := csvChanged(m)
+	if err != nil {
+		return fmt.Errorf("could not check CSV file for changes: %v", err)
+	}
+	if changed {
+		return errCSVChanged
+	}
+	rules, err := loadRules(m.WorkingDir)
+	if err != nil {
+		return fmt.Errorf("could not load tag rules: %v", err)
+	}
+	m.Images = projectConfig().Normalize.Images(m.Images)
+	m.Images, m.RuleChanges = rules.Images(m.Images)
+
+	// The CSV is written to memory first so that the file on disk can be
+	// replaced at once.
+	var buf bytes.Buffer
+	if err := m.csvWriter(&buf).WriteAll(m.Images); err != nil {
+		return err
+	}
+	if err := writeCSVFile(m.WorkingDir, m.CSVFilename, buf.Bytes()); err != nil {
+		return err
+	}
+	info, err := os.Stat(filepath.Join(m.WorkingDir, m.CSVFilename))
+	if err != nil {
+		return err
+	}
+	m.CSVStamp = stampCSVFile(m, info, buf.Bytes())
+	if err := saveColumns(m); err != nil {
+		return err
+	}
+	// Keep the hashes of the saved images so that their metadata can be found
+	// again if they get renamed.
+	return saveHashes(m)
 }
 
 const cachePublic1Year = "public, max-age=31536000"
@@ -367,14 +922,15 @@ func serveImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	img := bulk.FindByID(globalModel.Images, id)
+	img := bulk.FindByID(projectStore.Snapshot().Images, id)
 	if img == nil {
 		http.Error(w, fmt.Sprintf("no image found with ID: %v", id), http.StatusNotFound)
 		return
 	}
-	// In case of image name that ends with '.swf', we serve embedded image
-	// bytes from swf.go as it's not trivial to display a .swf file.
-	if strings.HasSuffix(img.Name, ".swf") {
+	// In case of media that cannot be displayed, like '.swf' files, we serve
+	// embedded image bytes from swf.go as a placeholder.
+	mt, _ := bulk.MediaTypes.Lookup(img.Name)
+	if mt.Preview == bulk.PreviewPlaceholder {
 		w.Header().Set("Cache-Control", cachePublic1Year)
 		if _, err := w.Write(swfImageBytes); err != nil {
 			http.Error(w, fmt.Sprintf("could not write image bytes: %v", err), http.StatusInternalServerError)
@@ -383,7 +939,11 @@ func serveImage(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	p := filepath.Join(*directory, img.Name)
+	p, err := safepath.Resolve(*directory, img.Name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("will not serve %v: %v", img.Name, err), http.StatusForbidden)
+		return
+	}
 	f, err := os.Open(p)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("could not open image: %v", err), http.StatusInternalServerError)
@@ -394,21 +954,24 @@ func serveImage(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error: could not close image file: %v\n", cerr)
 		}
 	}()
-	data, err := ioutil.ReadAll(f)
+	info, err := f.Stat()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("could not read image: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("could not stat image: %v", err), http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Cache-Control", cachePublic1Year)
-	if _, err = w.Write(data); err != nil {
-		http.Error(w, fmt.Sprintf("could not write image bytes: %v", err), http.StatusInternalServerError)
-		return
+	if mt.MIME != "" {
+		w.Header().Set("Content-Type", mt.MIME)
 	}
+	// ServeContent also handles range requests which browsers use to seek in
+	// videos.
+	http.ServeContent(w, r, img.Name, info.ModTime(), f)
 }
 
 func exitHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
 	}
 	if !*noexit {
 		os.Exit(0)
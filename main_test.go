@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/store"
+)
+
+// postForm returns the index page form posted from revision with the given
+// pairs of keys and values.
+func postForm(revision string, pairs ...string) url.Values {
+	form := url.Values{
+		"prefix":      {"/srv"},
+		"csvFilename": {"bulk.csv"},
+	}
+	if revision != "" {
+		form.Set("revision", revision)
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		form.Add(pairs[i], pairs[i+1])
+	}
+	return form
+}
+
+func TestApplyForm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// Revision 1 is what the form was rendered from and revision 2 has a
+	// change of the tags of a.png saved from another tab.
+	s := store.New(store.State{WorkingDir: dir, CSVFilename: "bulk.csv", Images: []bulk.Image{
+		{ID: 1, Name: "a.png", Tags: []string{"t1"}, Rating: "s"},
+		{ID: 2, Name: "b.png", Rating: "s"},
+	}})
+	if _, err := s.Update(func(st *store.State) error {
+		st.Images[0].Tags = []string{"t1", "t2"}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	projectStore = s
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		want      []bulk.Image
+		conflicts []editConflict
+	}{
+		{
+			name: "current version",
+			form: postForm("1", "image[2].name", "b.png", "image[2].version", "1", "image[2].tags", "t3", "image[2].rating", "q"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1", "t2"}, Rating: "s"},
+				{ID: 2, Name: "b.png", Tags: []string{"t3"}, Rating: "q"},
+			},
+		},
+		{
+			name: "stale version, other field",
+			form: postForm("1", "image[1].name", "a.png", "image[1].version", "1", "image[1].tags", "t1", "image[1].rating", "q"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1", "t2"}, Rating: "q"},
+				{ID: 2, Name: "b.png", Rating: "s"},
+			},
+		},
+		{
+			name: "stale version, same field",
+			form: postForm("1", "image[1].name", "a.png", "image[1].version", "1", "image[1].tags", "t1 t3", "image[1].rating", "q"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1", "t2"}, Rating: "q"},
+				{ID: 2, Name: "b.png", Rating: "s"},
+			},
+			conflicts: []editConflict{
+				{ID: 1, Conflict: bulk.Conflict{Name: "a.png", Field: bulk.FieldTags, A: "t1 t2", B: "t1 t3"}},
+			},
+		},
+		{
+			name: "stale version, same value",
+			form: postForm("1", "image[1].name", "a.png", "image[1].version", "1", "image[1].tags", "t1 t2"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1", "t2"}, Rating: "s"},
+				{ID: 2, Name: "b.png", Rating: "s"},
+			},
+		},
+		{
+			name: "revision no longer kept",
+			form: postForm("100", "image[1].name", "a.png", "image[1].version", "1", "image[1].tags", "t1", "image[1].rating", "s"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1", "t2"}, Rating: "s"},
+				{ID: 2, Name: "b.png", Rating: "s"},
+			},
+			conflicts: []editConflict{
+				{ID: 1, Conflict: bulk.Conflict{Name: "a.png", Field: bulk.FieldTags, A: "t1 t2", B: "t1"}},
+			},
+		},
+		{
+			name: "no revision",
+			form: postForm("", "image[1].name", "a.png", "image[1].tags", "t1"),
+			want: []bulk.Image{
+				{ID: 1, Name: "a.png", Tags: []string{"t1"}, Rating: "s"},
+				{ID: 2, Name: "b.png", Rating: "s"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		m := currentModel()
+		if err := applyForm(m, tt.form); err != nil {
+			t.Errorf("%s: applyForm returned err: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m.Images, tt.want) {
+			t.Errorf("%s: applyForm images => %+v, want %+v", tt.name, m.Images, tt.want)
+		}
+		if !reflect.DeepEqual(m.Conflicts, tt.conflicts) {
+			t.Errorf("%s: applyForm conflicts => %+v, want %+v", tt.name, m.Conflicts, tt.conflicts)
+		}
+	}
+}
+
+func TestCheckForm(t *testing.T) {
+	m := &model{State: store.State{Images: []bulk.Image{
+		{ID: 1, Name: "a.png"},
+		{ID: 2, Name: "b.png"},
+	}}}
+	tests := []struct {
+		name string
+		form url.Values
+		err  error
+	}{
+		{"same names", postForm("1", "image[1].name", "a.png", "image[2].name", "b.png"), nil},
+		{"no names", postForm("1", "image[1].tags", "t1"), nil},
+		{"reassigned ID", postForm("1", "image[1].name", "b.png", "image[2].name", "c.png"), errStaleForm},
+		{"removed image", postForm("1", "image[3].name", "c.png"), errStaleForm},
+	}
+	for _, tt := range tests {
+		if err := checkForm(m, tt.form); err != tt.err {
+			t.Errorf("%s: checkForm => %v, want %v", tt.name, err, tt.err)
+		}
+	}
+
+	// A rejected form changes nothing.
+	want := append([]bulk.Image(nil), m.Images...)
+	if err := applyForm(m, postForm("1", "image[1].name", "b.png", "image[1].tags", "t1")); err != errStaleForm {
+		t.Errorf("applyForm with reassigned ID => %v, want %v", err, errStaleForm)
+	}
+	if !reflect.DeepEqual(m.Images, want) {
+		t.Errorf("applyForm with reassigned ID changed images to %+v, want %+v", m.Images, want)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+// projectDir is the folder, under the working directory, that holds the
+// files tagaa keeps about the project besides the CSV file.
+const projectDir = ".tagaa"
+
+const hashesFilename = "hashes.json"
+
+// duplicatesDir and similarDir are the project folders where duplicate and
+// similar images are moved when another copy is kept.
+const (
+	duplicatesDir = "duplicates"
+	similarDir    = "similar"
+)
+
+const phashesFilename = "phashes.json"
+
+const indexFilename = "index.json"
+
+// ratingsFilename keeps the rating scheme the CSV file was last migrated to.
+const ratingsFilename = "ratings.json"
+
+// columnsFilename keeps the header row of the CSV file when it was saved with
+// a header row or extra columns, which the strict format does not have, so
+// that it is read in lenient mode.
+const columnsFilename = "columns.json"
+
+// backupsDir is the project folder that keeps previous versions of the CSV
+// file.
+const backupsDir = "backups"
+
+// projectPath returns the path of a project file under the working directory
+// dir.
+func projectPath(dir, name string) string {
+	return filepath.Join(dir, projectDir, name)
+}
+
+// loadHashes reads the hashes sidecar. A missing sidecar results in empty
+// hashes.
+func loadHashes(dir string) (bulk.Hashes, error) {
+	f, err := os.Open(projectPath(dir, hashesFilename))
+	if os.IsNotExist(err) {
+		return make(bulk.Hashes), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close hashes file: %v\n", cerr)
+		}
+	}()
+	return bulk.LoadHashes(f)
+}
+
+// saveHashes writes the hashes of the model images to the hashes sidecar.
+func saveHashes(m *model) (err error) {
+	if err = os.MkdirAll(filepath.Join(m.WorkingDir, projectDir), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(projectPath(m.WorkingDir, hashesFilename))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+	return bulk.SaveHashes(f, m.Images, m.Hashes)
+}
+
+// saveHashCache writes the hashes to the hashes sidecar as they are. The
+// hashes of names that are no longer found are kept until the CSV file is
+// saved, so that the metadata of renamed images can still be found.
+func saveHashCache(dir string, hashes bulk.Hashes) error {
+	if err := os.MkdirAll(filepath.Join(dir, projectDir), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := hashes.Save(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(dir, hashesFilename), buf.Bytes())
+}
+
+// move is a file renamed by a handler, kept so that it can be moved back if
+// the CSV file cannot be saved.
+type move struct {
+	from, to string
+}
+
+// moves are the files renamed by a handler in the order they were renamed.
+type moves []move
+
+// undo moves the files back in the reverse order they were moved. It carries
+// on after a failure and returns the first error.
+func (ms moves) undo() error {
+	var err error
+	for i := len(ms) - 1; i >= 0; i-- {
+		if rerr := os.Rename(ms[i].to, ms[i].from); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// saveMoved saves m with save after the files of moved were renamed. If saving
+// fails, the files are moved back so that they match the CSV file again.
+func saveMoved(m *model, moved moves, save func(*model) error) error {
+	err := save(m)
+	if err == nil {
+		return nil
+	}
+	err = fmt.Errorf("Error: could not save to CSV file: %w", err)
+	if uerr := moved.undo(); uerr != nil {
+		return fmt.Errorf("%w; the moved files could not be moved back: %v", err, uerr)
+	}
+	return err
+}
+
+// moveAside moves the image name, relative to the working directory dir, into
+// the project folder aside so that it is no longer loaded. If a file with the
+// same name was already moved there, a number is added to the name.
+func moveAside(dir, name, aside string) (move, error) {
+	src := filepath.Join(dir, filepath.FromSlash(name))
+	dst := projectPath(dir, filepath.Join(aside, filepath.FromSlash(name)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return move{}, err
+	}
+	ext := filepath.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(dst); os.IsNotExist(err) {
+			break
+		}
+		dst = fmt.Sprintf("%s.%d%s", base, i, ext)
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return move{}, err
+	}
+	return move{from: src, to: dst}, nil
+}
+
+// removeImages moves the files of images aside and removes them from the
+// model. It stops at the first image that cannot be moved and returns the
+// files that were moved.
+func removeImages(m *model, images []bulk.Image, aside string) (moves, error) {
+	var moved moves
+	removed := make(map[int]bool)
+	var err error
+	for _, img := range images {
+		var mv move
+		if mv, err = moveAside(m.WorkingDir, img.Name, aside); err != nil {
+			break
+		}
+		moved = append(moved, mv)
+		removed[img.ID] = true
+	}
+	kept := make([]bulk.Image, 0, len(m.Images))
+	for _, img := range m.Images {
+		if !removed[img.ID] {
+			kept = append(kept, img)
+		}
+	}
+	m.Images = kept
+	return moved, err
+}
+
+// loadPHashes reads the perceptual hashes cache. A missing cache results in
+// empty hashes.
+func loadPHashes(dir string) (bulk.PHashes, error) {
+	f, err := os.Open(projectPath(dir, phashesFilename))
+	if os.IsNotExist(err) {
+		return make(bulk.PHashes), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close perceptual hashes file: %v\n", cerr)
+		}
+	}()
+	return bulk.LoadPHashes(f)
+}
+
+// savePHashes writes the perceptual hashes cache.
+func savePHashes(dir string, phashes bulk.PHashes) error {
+	if err := os.MkdirAll(filepath.Join(dir, projectDir), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := bulk.SavePHashes(&buf, phashes); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(dir, phashesFilename), buf.Bytes())
+}
+
+// assignIDs gives the images their stable IDs from the project index, saving
+// the index if new IDs were given.
+func assignIDs(dir string, images []bulk.Image) error {
+	idx, err := loadIndex(dir)
+	if err != nil {
+		return err
+	}
+	if !idx.Assign(images) {
+		return nil
+	}
+	return saveIndex(dir, idx)
+}
+
+// loadIndex reads the project index. A missing index results in an empty one.
+func loadIndex(dir string) (*bulk.Index, error) {
+	f, err := os.Open(projectPath(dir, indexFilename))
+	if os.IsNotExist(err) {
+		return bulk.NewIndex(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			log.Printf("Error: could not close index file: %v\n", cerr)
+		}
+	}()
+	return bulk.LoadIndex(f)
+}
+
+// saveIndex writes the project index.
+func saveIndex(dir string, idx *bulk.Index) error {
+	if err := os.MkdirAll(filepath.Join(dir, projectDir), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := idx.Save(&buf); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(dir, indexFilename), buf.Bytes())
+}
+
+// hasColumns reports whether the CSV file was saved with a header row or extra
+// columns.
+func hasColumns(dir string) (bool, error) {
+	_, err := os.Stat(projectPath(dir, columnsFilename))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// saveColumns records whether the CSV file of m has a header row or extra
+// columns.
+func saveColumns(m *model) error {
+	if !m.extraColumns() {
+		if err := os.Remove(projectPath(m.WorkingDir, columnsFilename)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.MarshalIndent(m.Header, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(m.WorkingDir, projectDir), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(m.WorkingDir, columnsFilename), append(b, '\n'))
+}
+
+// loadRatings reads the rating scheme the CSV file was last migrated to. If
+// it was never migrated, it uses the default ratings.
+func loadRatings(dir string) (bulk.RatingScheme, error) {
+	b, err := ioutil.ReadFile(projectPath(dir, ratingsFilename))
+	if os.IsNotExist(err) {
+		return bulk.DefaultRatings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rs bulk.RatingScheme
+	if err := json.Unmarshal(b, &rs); err != nil {
+		return nil, fmt.Errorf("could not decode %v: %v", ratingsFilename, err)
+	}
+	return rs, nil
+}
+
+// saveRatings records that the CSV file uses the rating scheme rs.
+func saveRatings(dir string, rs bulk.RatingScheme) error {
+	b, err := json.MarshalIndent(rs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, projectDir), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(projectPath(dir, ratingsFilename), append(b, '\n'))
+}
+
+// migrateRatings remaps the ratings of the model images from the rating
+// scheme old to the configured one, with the ratingMap of the configuration,
+// and saves them. The change is not recorded in the journal since undoing it
+// would write ratings the new scheme does not have.
+func migrateRatings(m *model, old bulk.RatingScheme) error {
+	n := bulk.RemapRatings(m.Images, projectConfig().RatingMap)
+	for _, img := range m.Images {
+		if !bulk.Ratings.Valid(img.Rating) {
+			return fmt.Errorf("rating %q of %v is not one of %q; map it to one of them with ratingMap in %v", img.Rating, img.Name, bulk.Ratings.Values(), configFilename)
+		}
+	}
+	if n != 0 {
+		if err := writeToCSVFile(m); err != nil {
+			return err
+		}
+	}
+	log.Printf("Changed the rating scheme from %q to %q, %d images remapped", old.Values(), bulk.Ratings.Values(), n)
+	return saveRatings(m.WorkingDir, bulk.Ratings)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/store"
+)
+
+// errCSVChanged is returned by writeToCSVFile when the CSV file was changed by
+// another program since it was last read or written.
+var errCSVChanged = errors.New("the CSV file was changed by another program")
+
+// stampCSVFile returns the stamp of the CSV file of m with the file info and
+// content data. The info should be read before the content so that a change
+// made in between is not missed.
+func stampCSVFile(m *model, info os.FileInfo, data []byte) store.FileStamp {
+	sum := sha1.Sum(data)
+	return store.FileStamp{
+		Name:    m.CSVFilename,
+		ModTime: info.ModTime(),
+		SHA1:    hex.EncodeToString(sum[:]),
+	}
+}
+
+// csvChanged reports whether the CSV file of m changed since its stamp was
+// taken. A file that was not read or written yet, or that was removed, has not
+// changed. The file is only read if its modification time changed.
+func csvChanged(m *model) (bool, error) {
+	stamp := m.CSVStamp
+	if stamp.Name == "" || stamp.Name != m.CSVFilename {
+		return false, nil
+	}
+	filename := filepath.Join(m.WorkingDir, stamp.Name)
+	info, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(stamp.ModTime) {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, err
+	}
+	return stampCSVFile(m, info, data).SHA1 != stamp.SHA1, nil
+}
+
+// renderError renders t with err, unless err is that the CSV file was changed
+// by another program: then the changes of m are shown combined with the file
+// instead so that both can be kept.
+func renderError(w http.ResponseWriter, t *template.Template, m *model, err error) {
+	if !errors.Is(err, errCSVChanged) {
+		m.Err = err
+		render(w, t, m)
+		return
+	}
+	p, err := previewReconcile(m)
+	if err != nil {
+		m.Err = fmt.Errorf("Error: the CSV file was changed by another program and could not be read: %v", err)
+		render(w, t, m)
+		return
+	}
+	if err := addPendingImport(p); err != nil {
+		m.Err = fmt.Errorf("Error: the CSV file was changed by another program and could not be reconciled: %v", err)
+		render(w, t, m)
+		return
+	}
+	m.Import = p
+	render(w, loadTmpl, m)
+}
+
+// previewReconcile combines the images of m with the CSV file changed on disk
+// with a three-way merge. The base of the merge are the images as they were
+// when the file was last read or written, if they are still kept.
+func previewReconcile(m *model) (*importPreview, error) {
+	filename := filepath.Join(m.WorkingDir, m.CSVFilename)
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var base []bulk.Image
+	if snap, ok := projectStore.At(m.Revision); ok {
+		base = snap.Images
+	}
+	p, err := previewImport(m, bytes.NewReader(data), bulk.StrategyThreeWay, m.lenientCSV(), base)
+	if err != nil {
+		return nil, err
+	}
+	p.Filename = m.CSVFilename
+	p.Reconcile = true
+	p.SHA1 = stampCSVFile(m, info, data).SHA1
+	return p, nil
+}
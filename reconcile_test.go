@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/store"
+)
+
+func TestCSVChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+	filename := filepath.Join(dir, "bulk.csv")
+	content := []byte("/srv/pics/a.png,t1,,s,\n")
+	stamped := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		content []byte
+		modTime time.Time
+		remove  bool
+		want    bool
+	}{
+		{name: "unchanged", content: content, modTime: stamped, want: false},
+		{name: "modification time only", content: content, modTime: stamped.Add(time.Hour), want: false},
+		{name: "content", content: []byte("/srv/pics/a.png,t1 t2,,s,\n"), modTime: stamped.Add(time.Hour), want: true},
+		{name: "removed", remove: true, want: false},
+	}
+	for _, tt := range tests {
+		if err := ioutil.WriteFile(filename, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filename, stamped, stamped); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := &model{State: store.State{WorkingDir: dir, CSVFilename: "bulk.csv"}}
+		m.CSVStamp = stampCSVFile(m, info, content)
+
+		if tt.remove {
+			if err := os.Remove(filename); err != nil {
+				t.Fatal(err)
+			}
+		} else {
+			if err := ioutil.WriteFile(filename, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(filename, tt.modTime, tt.modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := csvChanged(m)
+		if err != nil {
+			t.Errorf("%s: csvChanged returned err: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: csvChanged => %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// A file that was not read yet or a different file has no changes.
+	for _, m := range []*model{
+		{State: store.State{WorkingDir: dir, CSVFilename: "bulk.csv"}},
+		{State: store.State{WorkingDir: dir, CSVFilename: "other.csv", CSVStamp: store.FileStamp{Name: "bulk.csv"}}},
+	} {
+		if got, err := csvChanged(m); got || err != nil {
+			t.Errorf("csvChanged(%+v) => %v, %v, want false, nil", m.CSVStamp, got, err)
+		}
+	}
+}
+
+func TestSaveMoved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tagaa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+	for _, name := range []string{"a.png", "b.png"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	images := []bulk.Image{{ID: 1, Name: "a.png"}, {ID: 2, Name: "b.png"}}
+	m := &model{State: store.State{WorkingDir: dir, Images: images}}
+
+	moved, err := removeImages(m, images[1:], duplicatesDir)
+	if err != nil {
+		t.Fatalf("removeImages returned err: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.png")); !os.IsNotExist(err) {
+		t.Fatalf("removeImages did not move %q aside: %v", "b.png", err)
+	}
+
+	// When saving fails the files are moved back.
+	err = saveMoved(m, moved, func(*model) error { return errCSVChanged })
+	if !errors.Is(err, errCSVChanged) {
+		t.Errorf("saveMoved returned err %v, want %v", err, errCSVChanged)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.png")); err != nil {
+		t.Errorf("saveMoved did not move %q back: %v", "b.png", err)
+	}
+	if _, err := os.Stat(moved[0].to); !os.IsNotExist(err) {
+		t.Errorf("saveMoved left %q aside: %v", moved[0].to, err)
+	}
+}
@@ -0,0 +1,86 @@
+// Package safepath checks that the file names given by users of tagaa, like
+// the name of the CSV file, refer to files inside the working directory.
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The errors returned when a name is rejected.
+var (
+	ErrEmpty   = errors.New("empty file name")
+	ErrOutside = errors.New("path is outside the working directory")
+	ErrSymlink = errors.New("path is a symbolic link to outside the working directory")
+	ErrNotCSV  = errors.New("file name does not have the .csv extension")
+)
+
+// Resolve returns the path of the file name in dir. name must be a relative
+// path, using either separator, that stays inside dir. If the file, or one of
+// the folders on its path, is a symbolic link, it must lead inside dir too.
+// The file does not need to exist.
+func Resolve(dir, name string) (string, error) {
+	if name == "" {
+		return "", ErrEmpty
+	}
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" || strings.HasPrefix(clean, string(filepath.Separator)) {
+		return "", ErrOutside
+	}
+	if clean == "." || !inside(".", clean) {
+		return "", ErrOutside
+	}
+	p := filepath.Join(dir, clean)
+
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", err
+	}
+	// The longest part of the path that exists is the one that may contain
+	// symbolic links.
+	existing := p
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if os.IsNotExist(err) {
+		// Only a link can exist and lead to nothing.
+		return "", ErrSymlink
+	}
+	if err != nil {
+		return "", err
+	}
+	if !inside(root, resolved) {
+		return "", ErrSymlink
+	}
+	return p, nil
+}
+
+// CSV is like Resolve but also requires name to have the .csv extension, in
+// any case.
+func CSV(dir, name string) (string, error) {
+	p, err := Resolve(dir, name)
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(filepath.Ext(name), ".csv") {
+		return "", ErrNotCSV
+	}
+	return p, nil
+}
+
+// inside reports whether the path p is root or is under root.
+func inside(root, p string) bool {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,136 @@
+package safepath_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kusubooru/tagaa/safepath"
+)
+
+// setup creates a working directory with files, folders and symbolic links
+// inside and outside of it, under a temporary directory. It returns the
+// temporary directory and the working directory.
+func setup(t *testing.T) (string, string) {
+	tmp, err := ioutil.TempDir("", "safepath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(tmp, "dir")
+	outside := filepath.Join(tmp, "outside")
+	for _, d := range []string{dir, filepath.Join(dir, "sub"), outside} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{
+		filepath.Join(dir, "a.csv"),
+		filepath.Join(dir, "sub", "b.csv"),
+		filepath.Join(dir, "img.png"),
+		filepath.Join(outside, "o.csv"),
+		filepath.Join(outside, "o.png"),
+	} {
+		if err := ioutil.WriteFile(f, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := []struct{ target, name string }{
+		{filepath.Join(outside, "o.csv"), filepath.Join(dir, "out.csv")},
+		{filepath.Join(outside, "o.png"), filepath.Join(dir, "out.png")},
+		{outside, filepath.Join(dir, "outdir")},
+		{filepath.Join(dir, "a.csv"), filepath.Join(dir, "in.csv")},
+		{filepath.Join(dir, "sub"), filepath.Join(dir, "insub")},
+		{dir, filepath.Join(tmp, "link")},
+		{filepath.Join(outside, "missing.csv"), filepath.Join(dir, "dangling.csv")},
+	}
+	for _, l := range links {
+		if err := os.Symlink(l.target, l.name); err != nil {
+			t.Skipf("cannot create symbolic links: %v", err)
+		}
+	}
+	return tmp, dir
+}
+
+func TestResolve(t *testing.T) {
+	tmp, dir := setup(t)
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		name string
+		path string
+		err  error
+	}{
+		{"a.csv", "a.csv", nil},
+		{"sub/b.csv", "sub/b.csv", nil},
+		{"sub/../a.csv", "a.csv", nil},
+		{"./img.png", "img.png", nil},
+		// Files that do not exist yet.
+		{"new.csv", "new.csv", nil},
+		{"sub/new/c.csv", "sub/new/c.csv", nil},
+		// Symbolic links inside the directory.
+		{"in.csv", "in.csv", nil},
+		{"insub/b.csv", "insub/b.csv", nil},
+		{"", "", safepath.ErrEmpty},
+		{".", "", safepath.ErrOutside},
+		{"../x.csv", "", safepath.ErrOutside},
+		{"../../.bashrc", "", safepath.ErrOutside},
+		{"sub/../../x.csv", "", safepath.ErrOutside},
+		{"/etc/passwd", "", safepath.ErrOutside},
+		{"out.csv", "", safepath.ErrSymlink},
+		{"out.png", "", safepath.ErrSymlink},
+		{"outdir/o.csv", "", safepath.ErrSymlink},
+		{"outdir/new.csv", "", safepath.ErrSymlink},
+		{"outdir", "", safepath.ErrSymlink},
+		{"dangling.csv", "", safepath.ErrSymlink},
+	}
+	for _, tt := range tests {
+		p, err := safepath.Resolve(dir, tt.name)
+		if err != tt.err {
+			t.Errorf("Resolve(dir, %q) returned err %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if tt.err != nil {
+			continue
+		}
+		if want := filepath.Join(dir, filepath.FromSlash(tt.path)); p != want {
+			t.Errorf("Resolve(dir, %q) => %q, want %q", tt.name, p, want)
+		}
+	}
+
+	// The working directory may itself be reached through a link.
+	link := filepath.Join(tmp, "link")
+	if _, err := safepath.Resolve(link, "a.csv"); err != nil {
+		t.Errorf("Resolve through linked directory returned err: %v", err)
+	}
+	if _, err := safepath.Resolve(link, "out.csv"); err != safepath.ErrSymlink {
+		t.Errorf("Resolve through linked directory of link to outside returned err %v, want %v", err, safepath.ErrSymlink)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	tmp, dir := setup(t)
+	defer os.RemoveAll(tmp)
+
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"a.csv", nil},
+		{"A.CSV", nil},
+		{"sub/b.csv", nil},
+		{"bulk.csv", nil},
+		{"img.png", safepath.ErrNotCSV},
+		{"bulk", safepath.ErrNotCSV},
+		{"bulk.csv.txt", safepath.ErrNotCSV},
+		{"", safepath.ErrEmpty},
+		{"../../.bashrc", safepath.ErrOutside},
+		{"../bulk.csv", safepath.ErrOutside},
+		{"out.csv", safepath.ErrSymlink},
+	}
+	for _, tt := range tests {
+		if _, err := safepath.CSV(dir, tt.name); err != tt.err {
+			t.Errorf("CSV(dir, %q) returned err %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
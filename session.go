@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+)
+
+// The server only serves the browser that opened the URL with the session
+// token, which is printed on start. The token is exchanged for a cookie so
+// that it does not stay in the address bar. Requests that change something
+// must also carry the CSRF token, which the pages put in their forms, so that
+// other web sites cannot make the browser send them.
+const (
+	sessionCookie = "tagaa_session"
+	tokenParam    = "token"
+	csrfField     = "csrf"
+	csrfHeader    = "X-CSRF-Token"
+)
+
+var (
+	sessionToken string
+	csrfToken    string
+)
+
+// newToken returns a random hex encoded token.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newSession creates the session and CSRF tokens.
+func newSession() error {
+	var err error
+	if sessionToken, err = newToken(); err != nil {
+		return err
+	}
+	csrfToken, err = newToken()
+	return err
+}
+
+func validToken(got, want string) bool {
+	return subtle.ConstantTimeCompare([]byte(got), []byte(want)) == 1
+}
+
+// guard only lets through h the requests of the session. /ok is always let
+// through since waitServer uses it to know when the server has started.
+func guard(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ok" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if t := r.URL.Query().Get(tokenParam); t != "" && validToken(t, sessionToken) {
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    sessionToken,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+			u := *r.URL
+			q := u.Query()
+			q.Del(tokenParam)
+			u.RawQuery = q.Encode()
+			http.Redirect(w, r, u.String(), http.StatusFound)
+			return
+		}
+		c, err := r.Cookie(sessionCookie)
+		if err != nil || !validToken(c.Value, sessionToken) {
+			http.Error(w, "Forbidden: open the address printed by tagaa when it started", http.StatusForbidden)
+			return
+		}
+		if r.Method != "GET" && r.Method != "HEAD" {
+			t := r.Header.Get(csrfHeader)
+			if t == "" {
+				t = r.FormValue(csrfField)
+			}
+			if !validToken(t, csrfToken) {
+				http.Error(w, "Forbidden: invalid CSRF token; reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// browserHost returns the host of the URL opened in the browser for a server
+// listening on host.
+func browserHost(host string) string {
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		return "localhost"
+	}
+	return host
+}
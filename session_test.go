@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	sessionToken, csrfToken = "session", "csrf"
+	h := guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	session := &http.Cookie{Name: sessionCookie, Value: "session"}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		cookie *http.Cookie
+		form   url.Values
+		code   int
+	}{
+		{"ok without session", "GET", "/ok", nil, nil, http.StatusOK},
+		{"missing cookie", "GET", "/", nil, nil, http.StatusForbidden},
+		{"wrong cookie", "GET", "/", &http.Cookie{Name: sessionCookie, Value: "other"}, nil, http.StatusForbidden},
+		{"wrong token", "GET", "/?token=other", nil, nil, http.StatusForbidden},
+		{"session", "GET", "/", session, nil, http.StatusOK},
+		{"missing CSRF token", "POST", "/update", session, url.Values{}, http.StatusForbidden},
+		{"bad CSRF token", "POST", "/update", session, url.Values{csrfField: {"other"}}, http.StatusForbidden},
+		{"CSRF token without session", "POST", "/update", nil, url.Values{csrfField: {"csrf"}}, http.StatusForbidden},
+		{"CSRF token", "POST", "/update", session, url.Values{csrfField: {"csrf"}}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		var r *http.Request
+		if tt.form != nil {
+			r = httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(tt.method, tt.target, nil)
+		}
+		if tt.cookie != nil {
+			r.AddCookie(tt.cookie)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.code {
+			t.Errorf("%s: %s %s => %d, want %d", tt.name, tt.method, tt.target, w.Code, tt.code)
+		}
+	}
+}
+
+func TestGuard_tokenExchange(t *testing.T) {
+	sessionToken, csrfToken = "session", "csrf"
+	h := guard(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("handler called for the token exchange")
+	}))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/dups?token=session&distance=5", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("token exchange => %d, want %d", w.Code, http.StatusFound)
+	}
+	if got, want := w.Header().Get("Location"), "/dups?distance=5"; got != want {
+		t.Errorf("token exchange redirects to %q, want %q", got, want)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != sessionCookie || cookies[0].Value != "session" || !cookies[0].HttpOnly {
+		t.Errorf("token exchange sets cookies %+v, want an HttpOnly %v cookie with the session token", cookies, sessionCookie)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func similarHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		serveSimilar(w, r)
+	case "POST":
+		handleSimilar(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func serveSimilar(w http.ResponseWriter, r *http.Request) {
+	m := reloadModel()
+	m.Distance = *distance
+	if d, err := strconv.Atoi(r.FormValue("distance")); err == nil {
+		m.Distance = d
+	}
+	findSimilar(m)
+	render(w, similarTmpl, m)
+}
+
+// findSimilar keeps in the model the pairs of similar images, using the
+// perceptual hashes computed when the images were loaded.
+func findSimilar(m *model) {
+	m.Similar = bulk.Similar(m.Images, m.Distance)
+}
+
+// handleSimilar applies the action posted for a pair of similar images a and
+// b. The copy actions copy the checked fields from one image to the other.
+// The keep actions merge the metadata of the other image into the kept one
+// and move the other file aside.
+func handleSimilar(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "could not parse form", http.StatusInternalServerError)
+		return
+	}
+	idA, ok := postedID(w, r, "a")
+	if !ok {
+		return
+	}
+	idB, ok := postedID(w, r, "b")
+	if !ok {
+		return
+	}
+	if idA == idB {
+		http.Error(w, fmt.Sprintf("images a and b are the same image: %v", idA), http.StatusBadRequest)
+		return
+	}
+	var fields []bulk.Field
+	for _, f := range r.PostForm["copy"] {
+		fields = append(fields, bulk.Field(f))
+	}
+	action := r.PostFormValue("action")
+	switch action {
+	case "copy-ab", "copy-ba", "keep-a", "keep-b":
+	default:
+		http.Error(w, fmt.Sprintf("unknown action %q", action), http.StatusBadRequest)
+		return
+	}
+
+	notFound := false
+	m, err := updateModel(func(m *model) error {
+		a := bulk.FindByID(m.Images, idA)
+		if a == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", idA)
+		}
+		// FindByID sorts the images so a copy of a is kept before looking
+		// for b.
+		imgA := *a
+		b := bulk.FindByID(m.Images, idB)
+		if b == nil {
+			notFound = true
+			return fmt.Errorf("no image found with ID: %v", idB)
+		}
+		a = bulk.FindByID(m.Images, imgA.ID)
+
+		var moved moves
+		var err error
+		switch action {
+		case "copy-ab":
+			bulk.CopyFields(b, *a, fields...)
+		case "copy-ba":
+			bulk.CopyFields(a, *b, fields...)
+		case "keep-a":
+			bulk.MergeInto(a, []bulk.Image{*b})
+			moved, err = removeImages(m, []bulk.Image{*b}, similarDir)
+		case "keep-b":
+			bulk.MergeInto(b, []bulk.Image{*a})
+			moved, err = removeImages(m, []bulk.Image{*a}, similarDir)
+		}
+		if err != nil {
+			return fmt.Errorf("Error: could not move image aside: %v", err)
+		}
+		return saveMoved(m, moved, saveToCSVFile)
+	})
+	if notFound {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	m.Distance = *distance
+	if d, err := strconv.Atoi(r.FormValue("distance")); err == nil {
+		m.Distance = d
+	}
+	if err != nil {
+		findSimilar(m)
+		renderError(w, similarTmpl, m, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/similar?distance=%d", m.Distance), http.StatusFound)
+}
+
+// postedID returns the image ID posted in the named form value. If it is not
+// a valid ID it replies with an error and returns false.
+func postedID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PostFormValue(name))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("%v is not a valid image ID", r.PostFormValue(name)), http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
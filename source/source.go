@@ -0,0 +1,223 @@
+// Package source turns the source URLs of images into a canonical form.
+//
+// Links to the same post come in many forms: mobile and desktop hosts, old
+// and new URL schemes, tracking parameters added when sharing and so on. Sites
+// with known rules, like pixiv, Twitter, Danbooru, DeviantArt and fanbox, are
+// turned to a single form. Other URLs only lose their fragment and common
+// tracking parameters.
+package source
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Normalize returns the canonical form of the source URL s. An empty source
+// stays empty. If s is not a web URL, it is returned as it is along with an
+// error.
+func Normalize(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", nil
+	}
+	u, err := parse(s)
+	if err != nil {
+		return s, err
+	}
+	for _, r := range rules {
+		if r.host.MatchString(u.Host) {
+			if c, ok := r.canonical(u); ok {
+				return c, nil
+			}
+			break
+		}
+	}
+	return clean(u), nil
+}
+
+// Valid reports whether s is empty or a web URL.
+func Valid(s string) bool {
+	_, err := Normalize(s)
+	return err == nil
+}
+
+// parse parses s as a web URL. A missing scheme is allowed if s starts with
+// something that looks like a host name, for example "pixiv.net/artworks/1".
+func parse(s string) (*url.URL, error) {
+	errNotURL := fmt.Errorf("%q is not a web URL", s)
+	if strings.ContainsAny(s, " \t\r\n") {
+		return nil, errNotURL
+	}
+	if strings.HasPrefix(s, "//") {
+		s = "https:" + s
+	} else if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, errNotURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errNotURL
+	}
+	if u.User != nil || !hostRx.MatchString(u.Hostname()) {
+		return nil, errNotURL
+	}
+	return u, nil
+}
+
+// hostRx matches host names with at least two labels.
+var hostRx = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+
+// tracking are query parameters that only track who shared a link.
+var tracking = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"igshid":  true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"ref_src": true,
+	"ref_url": true,
+}
+
+// clean drops the fragment and the tracking parameters of u and returns it as
+// a string.
+func clean(u *url.URL) string {
+	u.Fragment = ""
+	if u.RawQuery != "" {
+		q := u.Query()
+		for k := range q {
+			if tracking[k] || strings.HasPrefix(k, "utm_") {
+				q.Del(k)
+			}
+		}
+		u.RawQuery = q.Encode()
+	}
+	return u.String()
+}
+
+// rule is the canonical form of the URLs of a site. canonical reports false
+// for URLs of the site that it does not know, which are then only cleaned.
+type rule struct {
+	host      *regexp.Regexp
+	canonical func(u *url.URL) (string, bool)
+}
+
+var rules = []rule{
+	{regexp.MustCompile(`^((www|touch)\.)?pixiv\.net$`), pixiv},
+	{regexp.MustCompile(`^i\.pximg\.net$`), pximg},
+	{regexp.MustCompile(`^((www|mobile)\.)?(twitter|x|fxtwitter|vxtwitter|fixupx)\.com$`), twitter},
+	{regexp.MustCompile(`^([a-z]+\.)?donmai\.us$`), danbooru},
+	{regexp.MustCompile(`^([a-z0-9-]+\.)?deviantart\.com$`), deviantart},
+	{regexp.MustCompile(`^([a-z0-9-]+\.)?fanbox\.cc$`), fanbox},
+}
+
+var (
+	pixivArtworkRx = regexp.MustCompile(`^(/[a-z]{2})?/(artworks|i)/(\d+)/?$`)
+	pximgRx        = regexp.MustCompile(`/(\d+)_(p\d+|ugoira\d*)`)
+)
+
+// pixiv turns links to an illustration to
+// https://www.pixiv.net/artworks/ID.
+func pixiv(u *url.URL) (string, bool) {
+	if m := pixivArtworkRx.FindStringSubmatch(u.Path); m != nil {
+		return "https://www.pixiv.net/artworks/" + m[3], true
+	}
+	if strings.HasSuffix(u.Path, "/member_illust.php") {
+		if id := u.Query().Get("illust_id"); isNumber(id) {
+			return "https://www.pixiv.net/artworks/" + id, true
+		}
+	}
+	return "", false
+}
+
+// pximg turns the links of image files to the page of their illustration.
+func pximg(u *url.URL) (string, bool) {
+	if m := pximgRx.FindStringSubmatch(u.Path); m != nil {
+		return "https://www.pixiv.net/artworks/" + m[1], true
+	}
+	return "", false
+}
+
+var twitterStatusRx = regexp.MustCompile(`^/([A-Za-z0-9_]+)/status(es)?/(\d+)`)
+
+// twitter turns links to a tweet, from any of the Twitter or X hosts, to
+// https://twitter.com/USER/status/ID.
+func twitter(u *url.URL) (string, bool) {
+	if m := twitterStatusRx.FindStringSubmatch(u.Path); m != nil {
+		return "https://twitter.com/" + m[1] + "/status/" + m[3], true
+	}
+	return "", false
+}
+
+var danbooruPostRx = regexp.MustCompile(`^/(posts|post/show)/(\d+)`)
+
+// danbooru turns links to a post to https://HOST/posts/ID, keeping the host
+// since the Danbooru mirrors do not show the same posts.
+func danbooru(u *url.URL) (string, bool) {
+	if m := danbooruPostRx.FindStringSubmatch(u.Path); m != nil {
+		host := u.Host
+		if host == "donmai.us" || host == "www.donmai.us" {
+			host = "danbooru.donmai.us"
+		}
+		return "https://" + host + "/posts/" + m[2], true
+	}
+	return "", false
+}
+
+var (
+	deviantartArtRx     = regexp.MustCompile(`^/([A-Za-z0-9_-]+)/art/([A-Za-z0-9_-]*\d+)/?$`)
+	deviantartUserArtRx = regexp.MustCompile(`^/art/([A-Za-z0-9_-]*\d+)/?$`)
+)
+
+// deviantart turns links to a deviation, including the old
+// USER.deviantart.com ones, to https://www.deviantart.com/USER/art/TITLE-ID.
+func deviantart(u *url.URL) (string, bool) {
+	sub := strings.TrimSuffix(u.Host, ".deviantart.com")
+	if sub == "www" || sub == u.Host {
+		if m := deviantartArtRx.FindStringSubmatch(u.Path); m != nil {
+			return "https://www.deviantart.com/" + strings.ToLower(m[1]) + "/art/" + m[2], true
+		}
+		return "", false
+	}
+	if m := deviantartUserArtRx.FindStringSubmatch(u.Path); m != nil {
+		return "https://www.deviantart.com/" + sub + "/art/" + m[1], true
+	}
+	return "", false
+}
+
+var (
+	fanboxPostRx     = regexp.MustCompile(`^/posts/(\d+)/?$`)
+	fanboxUserPostRx = regexp.MustCompile(`^/@([A-Za-z0-9_-]+)/posts/(\d+)/?$`)
+)
+
+// fanbox turns links to a post to https://USER.fanbox.cc/posts/ID.
+func fanbox(u *url.URL) (string, bool) {
+	sub := strings.TrimSuffix(u.Host, ".fanbox.cc")
+	if sub == "www" || sub == u.Host {
+		if m := fanboxUserPostRx.FindStringSubmatch(u.Path); m != nil {
+			return "https://" + strings.ToLower(m[1]) + ".fanbox.cc/posts/" + m[2], true
+		}
+		return "", false
+	}
+	if m := fanboxPostRx.FindStringSubmatch(u.Path); m != nil {
+		return "https://" + sub + ".fanbox.cc/posts/" + m[1], true
+	}
+	return "", false
+}
+
+func isNumber(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,223 @@
+// Package store keeps the state of a tagaa project so that it can be shared
+// by concurrent HTTP handlers.
+//
+// The state is kept as a sequence of immutable snapshots. Readers get a copy
+// of the latest snapshot and never wait for writers. Writers are serialized:
+// each one works on a copy of the latest state and, if it succeeds, its copy
+// becomes the next snapshot. A writer that fails leaves the state as it was.
+//
+// Each image has a version too, the version of the snapshot in which its
+// metadata last changed, and a few past snapshots are kept, so that an edit
+// made on an old snapshot can tell which images were changed since.
+package store
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+// State is the state of a project: where it is, its images with their
+// metadata and the settings used to read and write its CSV file.
+type State struct {
+	WorkingDir  string
+	CSVFilename string
+	// Prefix is the server path prefix of the CSV file.
+	Prefix      string
+	UseLinuxSep bool
+	// Lenient is set when CSV files are loaded in lenient mode. It is kept
+	// once a CSV file was imported leniently since the saved file might have
+	// extra columns.
+	Lenient bool
+	// Header is the header row of the CSV file, if it was read with one in
+	// lenient mode. It is written back when the file is saved.
+	Header []string
+	Images []bulk.Image
+	// Hashes caches the content hashes of the images by name.
+	Hashes bulk.Hashes
+	// RuleChanges are the tags replaced or added by the tag rules on the
+	// last save, by image name.
+	RuleChanges map[string][]bulk.TagChange
+	// Versions are the versions of the images by name. They are kept by
+	// the store.
+	Versions map[string]int
+	// CSVStamp identifies the content of the CSV file when it was last read
+	// or written.
+	CSVStamp FileStamp
+}
+
+// FileStamp identifies the content of a file at some point so that changes
+// made to it by other programs can be detected.
+type FileStamp struct {
+	Name    string
+	ModTime time.Time
+	// SHA1 is the hex encoded SHA1 hash of the content.
+	SHA1 string
+}
+
+// Copy returns a deep copy of s that shares nothing with s.
+func (s State) Copy() State {
+	c := s
+	c.Header = copyStrings(s.Header)
+	if s.Images != nil {
+		c.Images = make([]bulk.Image, len(s.Images))
+		for i, img := range s.Images {
+			img.Tags = copyStrings(img.Tags)
+			img.Extra = copyStrings(img.Extra)
+			c.Images[i] = img
+		}
+	}
+	if s.Hashes != nil {
+		c.Hashes = make(bulk.Hashes, len(s.Hashes))
+		for k, v := range s.Hashes {
+			c.Hashes[k] = v
+		}
+	}
+	if s.Versions != nil {
+		c.Versions = make(map[string]int, len(s.Versions))
+		for k, v := range s.Versions {
+			c.Versions[k] = v
+		}
+	}
+	if s.RuleChanges != nil {
+		c.RuleChanges = make(map[string][]bulk.TagChange, len(s.RuleChanges))
+		for k, v := range s.RuleChanges {
+			c.RuleChanges[k] = append([]bulk.TagChange(nil), v...)
+		}
+	}
+	return c
+}
+
+func copyStrings(s []string) []string {
+	if s == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(s)), s...)
+}
+
+// Snapshot is a version of the state.
+type Snapshot struct {
+	// Version starts at 1 and increases by one with every successful
+	// update.
+	Version int
+	State
+}
+
+// Keep is the number of past snapshots kept by a store.
+const Keep = 32
+
+// Store holds the snapshots of the state of a project. It is safe for
+// concurrent use.
+type Store struct {
+	// update serializes the writers while mu only guards the switch to a
+	// new snapshot, so readers are not blocked by slow writers.
+	update sync.Mutex
+	mu     sync.RWMutex
+	cur    Snapshot
+	// past are the previous snapshots, oldest first.
+	past []Snapshot
+}
+
+// New returns a store whose first snapshot has the state s.
+func New(s State) *Store {
+	cur := Snapshot{Version: 1, State: s.Copy()}
+	cur.Versions = versions(nil, cur.Images, cur.Version)
+	return &Store{cur: cur}
+}
+
+// Snapshot returns a copy of the latest snapshot that the caller may modify
+// freely.
+func (st *Store) Snapshot() Snapshot {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return Snapshot{Version: st.cur.Version, State: st.cur.State.Copy()}
+}
+
+// Version returns the version of the latest snapshot.
+func (st *Store) Version() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.cur.Version
+}
+
+// At returns a copy of the snapshot with the given version. It reports false
+// if the version is not one of the latest Keep+1 versions.
+func (st *Store) At(version int) (Snapshot, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	if version == st.cur.Version {
+		return Snapshot{Version: st.cur.Version, State: st.cur.State.Copy()}, true
+	}
+	for _, snap := range st.past {
+		if snap.Version == version {
+			return Snapshot{Version: snap.Version, State: snap.State.Copy()}, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// Update calls fn with a copy of the latest state. If fn returns nil, the
+// state as left by fn becomes the next snapshot, which is returned, unless fn
+// left the state as it was: then the latest snapshot is returned and no new
+// version is made. If fn returns an error, the state is not changed and the
+// error is returned. Only one update runs at a time.
+func (st *Store) Update(fn func(s *State) error) (Snapshot, error) {
+	st.update.Lock()
+	defer st.update.Unlock()
+
+	prev := st.Snapshot()
+	next := st.Snapshot()
+	if err := fn(&next.State); err != nil {
+		return Snapshot{}, err
+	}
+	next.Versions = prev.Versions
+	if reflect.DeepEqual(next.State, prev.State) {
+		return prev, nil
+	}
+	next.Version++
+	next.Versions = versions(&prev, next.Images, next.Version)
+	// fn may keep references to the state it changed so the snapshot keeps
+	// its own copy.
+	committed := Snapshot{Version: next.Version, State: next.State.Copy()}
+	st.mu.Lock()
+	st.past = append(st.past, st.cur)
+	if len(st.past) > Keep {
+		st.past = st.past[len(st.past)-Keep:]
+	}
+	st.cur = committed
+	st.mu.Unlock()
+	return next, nil
+}
+
+// versions returns the versions of images that come after the snapshot
+// prev: the images whose metadata did not change keep their version and the
+// others get version v.
+func versions(prev *Snapshot, images []bulk.Image, v int) map[string]int {
+	old := make(map[string]bulk.Image)
+	if prev != nil {
+		for _, img := range prev.Images {
+			old[img.Name] = img
+		}
+	}
+	m := make(map[string]int, len(images))
+	for _, img := range images {
+		o, ok := old[img.Name]
+		if ok && sameMetadata(o, img) {
+			m[img.Name] = prev.Versions[img.Name]
+			continue
+		}
+		m[img.Name] = v
+	}
+	return m
+}
+
+func sameMetadata(a, b bulk.Image) bool {
+	for _, f := range []bulk.Field{bulk.FieldTags, bulk.FieldSource, bulk.FieldRating} {
+		if bulk.FieldValue(a, f) != bulk.FieldValue(b, f) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,186 @@
+package store_test
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+	"github.com/kusubooru/tagaa/store"
+)
+
+func newStore() *store.Store {
+	return store.New(store.State{
+		WorkingDir: "/pics",
+		Images: []bulk.Image{
+			{ID: 0, Name: "a.png", Tags: []string{"a"}},
+			{ID: 1, Name: "b.png", Tags: []string{"b"}},
+		},
+		Hashes: bulk.Hashes{"a.png": {SHA1: "1"}},
+	})
+}
+
+func TestStore_Update(t *testing.T) {
+	st := newStore()
+	if v := st.Version(); v != 1 {
+		t.Fatalf("Version of new store => %d, want 1", v)
+	}
+	snap, err := st.Update(func(s *store.State) error {
+		s.Images[0].Tags = append(s.Images[0].Tags, "added")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update returned err: %v", err)
+	}
+	if snap.Version != 2 {
+		t.Errorf("Update returned version %d, want 2", snap.Version)
+	}
+	if got := st.Snapshot().Images[0].Tags; len(got) != 2 || got[1] != "added" {
+		t.Errorf("Snapshot after Update => tags %q, want [a added]", got)
+	}
+
+	errFailed := errors.New("failed")
+	_, err = st.Update(func(s *store.State) error {
+		s.Images[0].Tags[0] = "changed"
+		s.WorkingDir = "/other"
+		return errFailed
+	})
+	if err != errFailed {
+		t.Errorf("failed Update returned err %v, want %v", err, errFailed)
+	}
+	snap = st.Snapshot()
+	if snap.Version != 2 || snap.WorkingDir != "/pics" || snap.Images[0].Tags[0] != "a" {
+		t.Errorf("failed Update changed the state to %+v", snap)
+	}
+}
+
+func TestStore_SnapshotIsCopy(t *testing.T) {
+	st := newStore()
+	snap := st.Snapshot()
+	snap.Images[0].Tags[0] = "changed"
+	snap.Hashes["a.png"] = bulk.Hash{SHA1: "changed"}
+	snap.Images = append(snap.Images[:1], bulk.Image{Name: "c.png"})
+
+	again := st.Snapshot()
+	if again.Images[0].Tags[0] != "a" || again.Hashes["a.png"].SHA1 != "1" || again.Images[1].Name != "b.png" {
+		t.Errorf("changing a snapshot changed the store: %+v", again)
+	}
+
+	// A state kept by an update function after it returns does not change
+	// the snapshot either.
+	var kept *store.State
+	if _, err := st.Update(func(s *store.State) error { kept = s; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	kept.Images[0].Tags[0] = "late"
+	if got := st.Snapshot().Images[0].Tags[0]; got != "a" {
+		t.Errorf("changing the state after Update changed the store: tag %q", got)
+	}
+}
+
+// TestStore_concurrent is meant to be run with -race.
+func TestStore_concurrent(t *testing.T) {
+	st := newStore()
+	const writers, updates = 8, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				_, err := st.Update(func(s *store.State) error {
+					s.Images[0].Tags = append(s.Images[0].Tags, fmt.Sprintf("w%d-%d", w, i))
+					bulk.FindByID(s.Images, 1)
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < updates; i++ {
+				snap := st.Snapshot()
+				// Readers may change their copy, like FindByID which sorts
+				// the images.
+				bulk.FindByID(snap.Images, 0)
+				snap.Images[0].Tags = nil
+			}
+		}()
+	}
+	wg.Wait()
+
+	snap := st.Snapshot()
+	if want := 1 + writers*updates; snap.Version != want {
+		t.Errorf("Version after concurrent updates => %d, want %d", snap.Version, want)
+	}
+	// No update was lost.
+	if got, want := len(snap.Images[0].Tags), 1+writers*updates; got != want {
+		t.Errorf("image has %d tags after concurrent updates, want %d", got, want)
+	}
+}
+
+func TestStore_Versions(t *testing.T) {
+	st := newStore()
+	snap := st.Snapshot()
+	if snap.Versions["a.png"] != 1 || snap.Versions["b.png"] != 1 {
+		t.Fatalf("image versions of new store => %v, want 1 for each image", snap.Versions)
+	}
+
+	snap, err := st.Update(func(s *store.State) error {
+		s.Images[1].Rating = "s"
+		s.Images = append(s.Images, bulk.Image{ID: 2, Name: "c.png"})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"a.png": 1, "b.png": 2, "c.png": 2}
+	for name, v := range want {
+		if got := snap.Versions[name]; got != v {
+			t.Errorf("version of %v => %d, want %d", name, got, v)
+		}
+	}
+
+	// An update that changes nothing makes no new version.
+	snap, err = st.Update(func(s *store.State) error {
+		s.Images[0].Tags = []string{"a"}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.Version != 2 || st.Version() != 2 {
+		t.Errorf("update without changes => version %d, want 2", snap.Version)
+	}
+}
+
+func TestStore_At(t *testing.T) {
+	st := newStore()
+	for i := 0; i < store.Keep+1; i++ {
+		_, err := st.Update(func(s *store.State) error {
+			s.Images[0].Tags = []string{fmt.Sprint(i)}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest := st.Version()
+	if _, ok := st.At(latest - store.Keep - 1); ok {
+		t.Errorf("At(%d) found a version older than the %d kept ones", latest-store.Keep-1, store.Keep)
+	}
+	snap, ok := st.At(latest - store.Keep)
+	if !ok {
+		t.Fatalf("At(%d) did not find the oldest kept version", latest-store.Keep)
+	}
+	if got := snap.Images[0].Tags[0]; got != "0" {
+		t.Errorf("At(%d) => tag %q, want %q", latest-store.Keep, got, "0")
+	}
+	snap.Images[0].Tags[0] = "changed"
+	if again, _ := st.At(latest - store.Keep); again.Images[0].Tags[0] != "0" {
+		t.Errorf("changing a past snapshot changed the store")
+	}
+}
@@ -9,7 +9,7 @@ import (
 
 func tagsHandler(w http.ResponseWriter, r *http.Request) {
 	q := r.FormValue("q")
-	tags, err := autocomplete.GetTags(q)
+	tags, err := autocomplete.GetTagsFrom(q, projectConfig().Autocomplete)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -7,8 +7,18 @@ import "html/template"
 var (
 	layoutTmpl = template.Must(template.New("layout").Funcs(fns).Parse(layoutTemplate))
 
+	backupsTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(backupsTemplate))
+
+	conflictsTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(conflictsTemplate))
+
+	duplicatesTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(duplicatesTemplate))
+
 	indexTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(indexTemplate))
 
+	loadTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(loadTemplate))
+
+	similarTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(similarTemplate))
+
 	uploadTmpl = template.Must(template.Must(layoutTmpl.Clone()).Parse(uploadTemplate))
 
 	layoutTemplate = `
@@ -38,6 +48,10 @@ var (
         background: #f2dede;
         color: #333;
       }
+      .block-warning {
+        background: #fcf8e3;
+        color: #333;
+      }
       .block-success {
         background: #dff0d8;
         color: #333;
@@ -92,10 +106,221 @@ var (
   </body>
 </html>
 {{ end }}
+{{ define "error" }}
+  <div class="block block-danger">
+    {{ with problems .Err }}
+      The CSV file could not be loaded because of the following problems:
+      <ul>
+        {{ range . }}
+          <li>Line {{ .Line }}{{ if .Column }}, column {{ .Column }}{{ end }}: {{ .Reason }}</li>
+        {{ end }}
+      </ul>
+    {{ else }}
+      {{ .Err }}
+    {{ end }}
+  </div>
+{{ end }}
 {{ define "style" }}{{end}}
 {{ define "script" }}{{end}}
 `
 
+	backupsTemplate = `
+{{ define "style" }}
+  <style>
+    .backups td, .backups th {
+      padding: 0.3em 1em 0.3em 0;
+      text-align: left;
+    }
+    .backup-changes {
+      font-size: 85%;
+      color: #555;
+    }
+  </style>
+{{end}}
+
+{{ define "content" }}
+  <nav>
+    <a href="/">Back</a>
+  </nav>
+
+  {{ if .Err }}
+    {{ template "error" . }}
+  {{ end }}
+
+  {{ if .Backups }}
+    <h2>Backups</h2>
+    <p>
+      Each save keeps the previous version of <code>{{ .CSVFilename }}</code>
+      in the <code>.tagaa/backups</code> folder. The changes column shows what
+      changed from the backup to the current file, which is what restoring the
+      backup would revert. The current file is backed up before it is
+      replaced.
+    </p>
+    <table class="backups">
+      <tr>
+        <th>Saved</th>
+        <th>Changes since</th>
+        <th></th>
+      </tr>
+      {{ range .Backups }}
+        <tr>
+          <td>{{ .Time.Format "2006-01-02 15:04:05" }}</td>
+          <td>
+            {{ if .Err }}
+              Could not read backup: {{ .Err }}
+            {{ else }}
+              {{ .Diff }}
+              <div class="backup-changes">
+                {{ range .Diff.Added }}<div>+ {{ .Name }}</div>{{ end }}
+                {{ range .Diff.Removed }}<div>- {{ .Name }}</div>{{ end }}
+                {{ range .Diff.Changed }}<div>~ {{ .New.Name }} ({{ range $i, $f := .Fields }}{{ if $i }}, {{ end }}{{ $f }}{{ end }})</div>{{ end }}
+              </div>
+            {{ end }}
+          </td>
+          <td>
+            <form action="/backups" method="POST">
+              <input type="hidden" name="csrf" value="{{ csrf }}">
+              <input type="hidden" name="backup" value="{{ .Name }}">
+              <input type="submit" value="Restore">
+            </form>
+          </td>
+        </tr>
+      {{ end }}
+    </table>
+  {{ else }}
+    <h2>No backups found</h2>
+    Backups are made when the CSV file is saved.
+  {{ end }}
+{{ end }}
+`
+	conflictsTemplate = `
+{{ define "style" }}
+  <style>
+    .conflicts td, .conflicts th {
+      padding: 0.3em 1em 0.3em 0;
+      text-align: left;
+      vertical-align: top;
+    }
+  </style>
+{{end}}
+
+{{ define "content" }}
+  <nav>
+    <a href="/">Back</a>
+  </nav>
+
+  <h2>Conflicts</h2>
+  <p>
+    Some of the images were saved from another tab or window after this page
+    was opened. Your other changes were saved but the fields below were
+    changed on both sides. Choose the value to keep for each of them.
+  </p>
+  <form action="/update" method="POST">
+    <input type="hidden" name="csrf" value="{{ csrf }}">
+    <input type="hidden" name="revision" value="{{ .Revision }}">
+    <input type="hidden" name="prefix" value="{{ .Prefix }}">
+    <input type="hidden" name="csvFilename" value="{{ .CSVFilename }}">
+    {{ if .UseLinuxSep }}
+      <input type="hidden" name="useLinuxSep" value="on">
+    {{ end }}
+    <input type="hidden" name="scroll" value="">
+    <table class="conflicts">
+      <tr>
+        <th>Image</th>
+        <th>Field</th>
+        <th>Keep</th>
+      </tr>
+      {{ range $i, $c := .Conflicts }}
+        <tr>
+          <td>
+            <a href="/#tags{{ $c.ID }}">{{ $c.Name }}</a>
+            <input type="hidden" name="image[{{ $c.ID }}].name" value="{{ $c.Name }}">
+            <input type="hidden" name="image[{{ $c.ID }}].version" value="{{ index $.Versions $c.Name }}">
+          </td>
+          <td>{{ $c.Field }}</td>
+          <td>
+            <input id="conflictA{{ $i }}" type="radio" name="image[{{ $c.ID }}].{{ $c.Field }}" value="{{ $c.A }}" checked>
+            <label for="conflictA{{ $i }}">Saved: <code>{{ $c.A }}</code></label>
+            <br>
+            <input id="conflictB{{ $i }}" type="radio" name="image[{{ $c.ID }}].{{ $c.Field }}" value="{{ $c.B }}">
+            <label for="conflictB{{ $i }}">Yours: <code>{{ $c.B }}</code></label>
+          </td>
+        </tr>
+      {{ end }}
+    </table>
+    <input type="submit" value="Save">
+  </form>
+{{end}}
+`
+	duplicatesTemplate = `
+{{ define "style" }}
+  <style>
+    .duplicate-group {
+      margin-bottom: 1em;
+    }
+    .duplicate {
+      display: inline-block;
+      vertical-align: top;
+      width: 200px;
+      margin-right: 1em;
+    }
+    .duplicate img, .duplicate video {
+      max-width: 100%;
+      max-height: 150px;
+    }
+    .duplicate-tags {
+      font-size: 85%;
+      color: #555;
+    }
+  </style>
+{{end}}
+
+{{ define "content" }}
+  <nav>
+    <a href="/">Back</a>
+  </nav>
+
+  {{ if .Err }}
+    {{ template "error" . }}
+  {{ end }}
+
+  {{ if .Duplicates }}
+    <h2>Duplicates</h2>
+    <p>
+      The images of each group below have identical content. Choose the one to
+      keep. The tags of the others are merged into it, their source and rating
+      are used if it has none, and the other files are moved to the
+      <code>.tagaa/duplicates</code> folder.
+    </p>
+  {{ else }}
+    <h2>No duplicates found in local directory</h2>
+  {{ end }}
+
+  {{ range .Duplicates }}
+    <form class="duplicate-group" action="/duplicates" method="POST">
+      <input type="hidden" name="csrf" value="{{ csrf }}">
+      <fieldset>
+        {{ range $i, $img := . }}
+          <label class="duplicate">
+            {{ if eq (preview .Name) "video" }}
+              <video src="/img/{{ .ID }}" muted preload="metadata"></video>
+            {{ else }}
+              <img src="/img/{{ .ID }}" alt="{{ .Name }}">
+            {{ end }}
+            <br>
+            <input type="radio" name="keep" value="{{ .ID }}" {{ if eq $i 0 }}checked{{ end }}>
+            {{ .Name }}
+            <br>
+            <span class="duplicate-tags">{{ join .Tags " " }}</span>
+          </label>
+        {{ end }}
+        <br>
+        <input type="submit" value="Keep selected">
+      </fieldset>
+    </form>
+  {{ end }}
+{{ end }}
+`
 	indexTemplate = `
 {{ define "style" }}
   <style>
@@ -119,17 +344,29 @@ var (
     .tag {
       color: #0073ff;
     }
-    .tag-tk {
-      color: #ee5542;
+    {{ range categories }}
+    .tag-{{ .Name }} {
+      color: {{ .Color }};
     }
-    .tag-artist {
-      color: #a00;
+    {{ end }}
+    .inline-form {
+      display: inline;
     }
-    .tag-series {
-      color: #a0a;
+    .history {
+      margin-top: 0.6em;
+      font-size: 85%;
     }
-    .tag-character {
-      color: #0a0;
+    .history td {
+      padding-right: 1em;
+      vertical-align: top;
+    }
+    .server-path {
+      font-size: 85%;
+      color: #555;
+      margin-bottom: 0.6em;
+    }
+    .rule-changes {
+      margin: 0;
     }
     .tag-count {
       float: right;
@@ -165,45 +402,94 @@ var (
 {{ define "content" }}
   <nav>
     <a href="/upload">Upload</a>
+    <a href="/duplicates">Duplicates</a>
+    <a href="/similar">Similar</a>
+    <a href="/backups">Backups</a>
+    <form class="inline-form" action="/undo" method="POST">
+      <input type="hidden" name="csrf" value="{{ csrf }}">
+      <input type="submit" value="Undo" {{ if not .CanUndo }}disabled{{ end }}>
+    </form>
+    <form class="inline-form" action="/redo" method="POST">
+      <input type="hidden" name="csrf" value="{{ csrf }}">
+      <input type="submit" value="Redo" {{ if not .CanRedo }}disabled{{ end }}>
+    </form>
   </nav>
 
   {{ if .Err }}
-    <div class="block block-danger">
-      {{ .Err }}
-    </div>
+    {{ template "error" . }}
   {{ end }}
   <form action="/load" method="POST" enctype="multipart/form-data">
+    <input type="hidden" name="csrf" value="{{ csrf }}">
     <label for="loadCSVFile"><b>Load CSV File</b></label>
     <br>
     <input id="loadCSVFile" name="csvFilename" type="file" accept=".csv" required>
+    <select name="strategy" title="How the metadata of the loaded file is combined with the current metadata">
+      <option value="overwrite">Overwrite</option>
+      <option value="keep">Keep current</option>
+      <option value="union">Union of tags</option>
+      <option value="threeway" selected>Three-way merge</option>
+    </select>
     <input type="submit" value="Load from CSV">
+    <input id="lenientInput" type="checkbox" name="lenient" {{ if .Lenient }}checked{{ end }}>
+    <label for="lenientInput" title="Accept CSV files with a header row, missing or extra columns">Lenient</label>
     <button id="toggleButton" type="button">Advanced +</button>
     <br>
   </form>
   <form action="/update" method="POST">
+    <input type="hidden" name="csrf" value="{{ csrf }}">
     <div id="advanced">
       <label for="csvFilenameInput"><b>CSV Filename</b></label>
       <br>
       <input id="csvFilenameInput" type="text" name="csvFilename" value="{{ .CSVFilename }}" class="chomp">
       <input id="saveCSVSubmit" type="submit" value="Save to CSV">
+      <input type="submit" formaction="/sources" value="Normalize all sources" title="Turn every source URL to its canonical form and save">
       <br>
       <label for="directory"><b>Working Directory</b></label>
       <br>
       <input id="directory" type="text" name="prefix" value="{{ .WorkingDir }}" disabled class="chomp">
       <br>
-      <label for="prefixInput"><b>Server Path Prefix</b> (It will replace working directory path prefix)</label>
+      {{ if .Config.Paths }}
+        <b>Server Path Rules</b> (from the <code>paths</code> of <code>.tagaa.json</code>)
+        <ul>
+          {{ range .Config.Paths }}
+            <li><code>{{ .Local }}</code> → <code>{{ .Server }}</code></li>
+          {{ end }}
+        </ul>
+        <input type="hidden" name="prefix" value="{{ .Prefix }}">
+      {{ else }}
+        <label for="prefixInput"><b>Server Path Prefix</b> (It will replace working directory path prefix)</label>
+        <br>
+        <input id="prefixInput" type="text" name="prefix" value="{{ .Prefix }}" class="chomp">
+        <br>
+        <label for="useLinuxSepInput"><b>Use Linux Separator "/" when saving to CSV</b> </label>
+        <br>
+        <input id="useLinuxSepInput" type="checkbox" name="useLinuxSep" {{if eq .UseLinuxSep true}}checked{{end}}>
+        (Check, if working on a windows machine and want to upload to a Linux machine)
+        <br>
+      {{ end }}
+      <label for="uploadURLInput"><b>Upload URL</b></label>
+      <br>
+      <input id="uploadURLInput" type="text" name="uploadURL" value="{{ .Config.UploadURL }}" class="chomp">
       <br>
-      <input id="prefixInput" type="text" name="prefix" value="{{ .Prefix }}" class="chomp">
+      <b>Tag suggestions from</b>
+      <br>
+      {{ $sources := .Config.Autocomplete }}
+      {{ range boards }}
+        <input id="autocomplete-{{ . }}" type="checkbox" name="autocomplete" value="{{ . }}" {{ if has $sources . }}checked{{ end }}>
+        <label for="autocomplete-{{ . }}">{{ . }}</label>
+      {{ end }}
       <br>
-      <label for="useLinuxSepInput"><b>Use Linux Separator "/" when saving to CSV</b> </label>
+      <input id="lenientSettingInput" type="checkbox" name="lenientSetting" {{ if .Config.Lenient }}checked{{ end }}>
+      <label for="lenientSettingInput"><b>Load CSV files in lenient mode</b></label>
       <br>
-      <input id="useLinuxSepInput" type="checkbox" name="useLinuxSep" {{if eq .UseLinuxSep true}}checked{{end}}>
-      (Check, if working on a windows machine and want to upload to a Linux machine)
+      <input type="submit" formaction="/settings" value="Save settings">
+      (Stores the settings above in the <code>.tagaa.json</code> file of the working directory)
       <br>
       <input id="deleteCacheKey" type="text">
       <button id="deleteCacheButton" type="button">Delete Tag from Cache</button>
       <input id="scroll" type="hidden" name="scroll" value="">
     </div>
+    <input type="hidden" name="revision" value="{{ .Revision }}">
 
     <section>
       {{ if .Images }}
@@ -219,8 +505,49 @@ var (
             <a id="tags{{ .ID }}"></a>
             <a id="img{{ .ID }}"></a>
             <legend>{{ .Name }}</legend>
-            <a href="#img{{ .ID }}"><img class="image" src="/img/{{ .ID }}" alt="{{ .Name }}"></a>
+            <input type="hidden" name="image[{{ .ID }}].name" value="{{ .Name }}">
+            <input type="hidden" name="image[{{ .ID }}].version" value="{{ index $.Versions .Name }}">
+            {{ with $.ServerPath . }}
+              <div class="server-path">Server path: <code>{{ . }}</code></div>
+            {{ else }}
+              <div class="block block-warning">
+                No server path rule matches this image so the CSV file cannot be saved.
+              </div>
+            {{ end }}
+            {{ if eq .Content.String "mismatch" }}
+              <div class="block block-warning">
+                The content of this file is <b>{{ .Detected }}</b> which does not match its extension.
+                <button type="submit" formaction="/fixext" name="fixext" value="{{ .ID }}">Fix extension</button>
+              </div>
+            {{ else if eq .Content.String "notmedia" }}
+              <div class="block block-warning">
+                The content of this file is not a supported media type. It might be a broken download.
+              </div>
+            {{ else if eq .Content.String "unreadable" }}
+              <div class="block block-warning">
+                This file cannot be read. It might be a broken link or be missing permissions.
+              </div>
+            {{ end }}
+            {{ if eq (preview .Name) "video" }}
+              <video class="image" src="/img/{{ .ID }}" controls muted preload="metadata"></video>
+            {{ else }}
+              <a href="#img{{ .ID }}"><img class="image" src="/img/{{ .ID }}" alt="{{ .Name }}"></a>
+            {{ end }}
             <br>
+            {{ with index $.RuleChanges .Name }}
+              <div class="block">
+                Tag rules applied on save:
+                <ul class="rule-changes">
+                  {{ range . }}
+                    {{ if .Implied }}
+                      <li>added <b>{{ .New }}</b> (implied by {{ .Old }})</li>
+                    {{ else }}
+                      <li>replaced {{ .Old }} with <b>{{ .New }}</b></li>
+                    {{ end }}
+                  {{ end }}
+                </ul>
+              </div>
+            {{ end }}
             <label for="tagsTextArea{{ .ID }}"><b>Tags</b></label>
             <div id="loader{{ .ID }}" class="loader loader-small"></div>
             <br>
@@ -230,16 +557,35 @@ var (
             <br>
             <input id="sourceInput{{ .ID }}" class="medium-input" type="text" name="image[{{ .ID }}].source" value="{{ .Source }}" >
             <br>
+            {{ with sourceError .Source }}
+              <div class="block block-warning">Source {{ . }}.</div>
+            {{ end }}
             <label><b>Rating</b></label>
             <br>
-            <input id="sRadio{{ .ID }}" type="radio" name="image[{{ .ID }}].rating" value="s" {{ if eq .Rating "s" }}checked{{ end }}>
-            <label for="sRadio{{ .ID }}">Safe</label>
-            <input id="qRadio{{ .ID }}" type="radio" name="image[{{ .ID }}].rating" value="q" {{ if eq .Rating "q" }}checked{{ end }}>
-            <label for="qRadio{{ .ID }}">Questionable</label>
-            <input id="eRadio{{ .ID }}" type="radio" name="image[{{ .ID }}].rating" value="e" {{ if eq .Rating "e" }}checked{{ end }}>
-            <label for="eRadio{{ .ID }}">Explicit</label>
+            {{ $img := . }}
+            {{ range $i, $r := ratings }}
+              <input id="ratingRadio{{ $img.ID }}-{{ $i }}" type="radio" name="image[{{ $img.ID }}].rating" value="{{ $r.Value }}" {{ if eq $img.Rating $r.Value }}checked{{ end }}>
+              <label for="ratingRadio{{ $img.ID }}-{{ $i }}">{{ $r.Name }}</label>
+            {{ end }}
             <br>
             <input class="save-to-csv" type="submit" value="Save to CSV" data-scroll="#tags{{.ID}}">
+            {{ $id := .ID }}
+            {{ with index $.History .Name }}
+              <details class="history">
+                <summary>History ({{ len . }})</summary>
+                <table>
+                  {{ range $i, $e := . }}
+                    <tr>
+                      <td>{{ .Time.Format "2006-01-02 15:04:05" }}</td>
+                      <td>{{ .Op }}</td>
+                      <td>{{ .Field }}</td>
+                      <td><del>{{ .Old }}</del> → {{ .New }}</td>
+                      <td><button type="submit" formaction="/rollback" name="rollback" value="{{ $id }}:{{ $i }}">Restore old {{ .Field }}</button></td>
+                    </tr>
+                  {{ end }}
+                </table>
+              </details>
+            {{ end }}
           </fieldset>
         </article>
         <br>
@@ -263,6 +609,7 @@ var (
       function exitHandler(event) {
         var xhr = new XMLHttpRequest();
         xhr.open("POST", "exit", true);
+        xhr.setRequestHeader("X-CSRF-Token", "{{ csrf }}");
         xhr.send();
       };
 
@@ -362,19 +709,12 @@ var (
           sort: false
         });
       }
+      // The colors of the categories come from the project configuration.
       function categoryClass(category) {
-        switch (category) {
-          case "tk":
-            return "tag-tk";
-          case "artist":
-            return "tag-artist";
-          case "series":
-            return "tag-series";
-          case "character":
-            return "tag-character";
-          default:
-            return "tag";
+        if (!category || category == "normal" || category == "unknown") {
+          return "tag";
         }
+        return "tag tag-" + category;
       }
 
       var timeout = null;
@@ -451,6 +791,200 @@ var (
     })();
   </script>
 {{ end }}
+`
+	loadTemplate = `
+{{ define "style" }}
+  <style>
+    .load-changes td, .load-changes th {
+      padding: 0.3em 1em 0.3em 0;
+      text-align: left;
+      vertical-align: top;
+    }
+    .tag-added {
+      color: #0a0;
+    }
+    .tag-removed {
+      color: #a00;
+      text-decoration: line-through;
+    }
+  </style>
+{{end}}
+
+{{ define "content" }}
+  <nav>
+    <a href="/">Cancel</a>
+  </nav>
+
+  {{ if .Err }}
+    {{ template "error" . }}
+  {{ end }}
+
+  {{ with .Import }}
+    {{ if .Reconcile }}
+      <h2>{{ .Filename }} was changed by another program</h2>
+      <p>
+        The CSV file was changed on disk since it was loaded, so your changes
+        were not saved. They are combined below with the changes made to the
+        file. {{ .Diff }} compared to your version.
+        {{ if .Unmatched }}{{ .Unmatched }} of the images in the file were not found and are ignored.{{ end }}
+        {{ if not .HasBase }}
+          The version the changes were made from is no longer known, so only
+          tags added on either side are kept and any other difference is a
+          conflict.
+        {{ end }}
+        Nothing is saved until the changes are applied.
+      </p>
+    {{ else }}
+      <h2>Load {{ .Filename }}</h2>
+      <p>
+        Strategy: <b>{{ .Strategy }}</b>.
+        {{ .Diff }}.
+        {{ if .Unmatched }}{{ .Unmatched }} of the images in the file were not found and are ignored.{{ end }}
+        {{ if and (eq .Strategy "threeway") (not .HasBase) }}
+          There is no base from a previous load yet, so only tags added on either
+          side are kept and any other difference is a conflict.
+        {{ end }}
+        Nothing is saved until the changes are applied.
+      </p>
+    {{ end }}
+    <form action="/load/apply" method="POST">
+      <input type="hidden" name="csrf" value="{{ csrf }}">
+      <input type="hidden" name="import" value="{{ .Token }}">
+      {{ if .Conflicts }}
+        <h3>Conflicts</h3>
+        <table class="load-changes">
+          <tr>
+            <th>Image</th>
+            <th>Field</th>
+            <th>Keep</th>
+          </tr>
+          {{ range $i, $c := .Conflicts }}
+            <tr>
+              <td>{{ $c.Name }}</td>
+              <td>{{ $c.Field }}</td>
+              <td>
+                <input id="conflictA{{ $i }}" type="radio" name="conflict[{{ $i }}]" value="a" checked>
+                <label for="conflictA{{ $i }}">{{ if $.Import.Reconcile }}Yours{{ else }}Current{{ end }}: <code>{{ $c.A }}</code></label>
+                <br>
+                <input id="conflictB{{ $i }}" type="radio" name="conflict[{{ $i }}]" value="b">
+                <label for="conflictB{{ $i }}">{{ if $.Import.Reconcile }}On disk{{ else }}Loaded{{ end }}: <code>{{ $c.B }}</code></label>
+              </td>
+            </tr>
+          {{ end }}
+        </table>
+      {{ end }}
+
+      {{ if .Diff.Changed }}
+        <h3>Changes</h3>
+        <table class="load-changes">
+          <tr>
+            <th>Image</th>
+            <th>Tags</th>
+            <th>Source</th>
+            <th>Rating</th>
+          </tr>
+          {{ range .Diff.Changed }}
+            <tr>
+              <td>{{ .New.Name }}</td>
+              <td>
+                {{ $changes := tagChanges .Old.Tags .New.Tags }}
+                {{ range index $changes 0 }}<span class="tag-added">{{ . }}</span> {{ end }}
+                {{ range index $changes 1 }}<span class="tag-removed">{{ . }}</span> {{ end }}
+              </td>
+              <td>{{ if ne .Old.Source .New.Source }}<code>{{ .Old.Source }}</code> → <code>{{ .New.Source }}</code>{{ end }}</td>
+              <td>{{ if ne .Old.Rating .New.Rating }}<code>{{ .Old.Rating }}</code> → <code>{{ .New.Rating }}</code>{{ end }}</td>
+            </tr>
+          {{ end }}
+        </table>
+      {{ end }}
+      <input type="submit" value="Apply and save">
+    </form>
+  {{ end }}
+{{ end }}
+`
+	similarTemplate = `
+{{ define "style" }}
+  <style>
+    .similar-pair {
+      margin-bottom: 1em;
+    }
+    .similar {
+      display: inline-block;
+      vertical-align: top;
+      width: 45%;
+      margin-right: 1em;
+    }
+    .similar img {
+      max-width: 100%;
+      max-height: 300px;
+    }
+    .similar-tags {
+      font-size: 85%;
+      color: #555;
+    }
+  </style>
+{{end}}
+
+{{ define "content" }}
+  <nav>
+    <a href="/">Back</a>
+  </nav>
+
+  {{ if .Err }}
+    {{ template "error" . }}
+  {{ end }}
+
+  <form action="/similar" method="GET">
+    <label for="distanceInput"><b>Maximum distance</b> (0 for nearly identical, up to 64)</label>
+    <input id="distanceInput" type="number" name="distance" min="0" max="64" value="{{ .Distance }}">
+    <input type="submit" value="Search">
+  </form>
+
+  {{ if .Similar }}
+    <h2>Similar images</h2>
+  {{ else }}
+    <h2>No similar images found in local directory</h2>
+  {{ end }}
+
+  {{ range .Similar }}
+    <form class="similar-pair" action="/similar" method="POST">
+      <input type="hidden" name="csrf" value="{{ csrf }}">
+      <fieldset>
+        <legend>Distance {{ .Distance }}</legend>
+        <input type="hidden" name="a" value="{{ .A.ID }}">
+        <input type="hidden" name="b" value="{{ .B.ID }}">
+        <input type="hidden" name="distance" value="{{ $.Distance }}">
+        {{ range (list .A .B) }}
+          <div class="similar">
+            <img src="/img/{{ .ID }}" alt="{{ .Name }}">
+            <br>
+            <b>{{ .Name }}</b>
+            <br>
+            <span class="similar-tags">{{ join .Tags " " }}</span>
+            <br>
+            Source: {{ .Source }}
+            <br>
+            Rating: {{ .Rating }}
+          </div>
+        {{ end }}
+        <br>
+        Copy
+        <input id="copyTags{{ .A.ID }}-{{ .B.ID }}" type="checkbox" name="copy" value="tags" checked>
+        <label for="copyTags{{ .A.ID }}-{{ .B.ID }}">tags</label>
+        <input id="copySource{{ .A.ID }}-{{ .B.ID }}" type="checkbox" name="copy" value="source" checked>
+        <label for="copySource{{ .A.ID }}-{{ .B.ID }}">source</label>
+        <input id="copyRating{{ .A.ID }}-{{ .B.ID }}" type="checkbox" name="copy" value="rating" checked>
+        <label for="copyRating{{ .A.ID }}-{{ .B.ID }}">rating</label>
+        <button type="submit" name="action" value="copy-ab">Left to right</button>
+        <button type="submit" name="action" value="copy-ba">Right to left</button>
+        <br>
+        <button type="submit" name="action" value="keep-a">Keep left</button>
+        <button type="submit" name="action" value="keep-b">Keep right</button>
+        (The other copy is moved to the <code>.tagaa/similar</code> folder and its metadata merged.)
+      </fieldset>
+    </form>
+  {{ end }}
+{{ end }}
 `
 	uploadTemplate = `
 {{ define "style" }}
@@ -470,6 +1004,13 @@ var (
     .upload-table textarea {
       width: 95%;
     }
+    .thumbnail video {
+      width: 100%;
+      height: auto;
+    }
+    .content-warning {
+      color: #a00;
+    }
     .upload-button {
       display: inline-block;
       padding: 0.5em;
@@ -483,9 +1024,7 @@ var (
   </nav>
 
   {{ if .Err }}
-    <div class="block block-danger">
-      {{ .Err }}
-    </div>
+    {{ template "error" . }}
   {{ else if .Success }}
     <div class="block block-success">
      {{ .Success }}
@@ -493,6 +1032,7 @@ var (
   {{ end }}
 
   <form action="/upload" method="POST" enctype="multipart/form-data" onsubmit="showLoader()">
+    <input type="hidden" name="csrf" value="{{ csrf }}">
     <table class="upload-table">
       <thead>
         <tr>
@@ -508,11 +1048,19 @@ var (
           <tr>
             <td>
               <div class="thumbnail">
-                <a href="#img{{ .ID }}"><img src="/img/{{ .ID }}" alt="{{ .Name }}" width=150 height=100></a>
+                {{ if eq (preview .Name) "video" }}
+                  <video src="/img/{{ .ID }}" muted preload="metadata" width=150 height=100></video>
+                {{ else }}
+                  <a href="#img{{ .ID }}"><img src="/img/{{ .ID }}" alt="{{ .Name }}" width=150 height=100></a>
+                {{ end }}
               </div>
             </td>
             <td width="10%">
               {{ .Name }}
+              {{ if not (uploadable .Name) }}<br><small>(not uploaded)</small>{{ end }}
+              {{ if eq .Content.String "mismatch" }}<br><small class="content-warning">(content is {{ .Detected }})</small>{{ end }}
+              {{ if eq .Content.String "notmedia" }}<br><small class="content-warning">(not a media file)</small>{{ end }}
+              {{ if eq .Content.String "unreadable" }}<br><small class="content-warning">(cannot be read)</small>{{ end }}
             </td>
             <td width="65%">
               <textarea id="tagsTextArea{{ .ID }}" name="image[{{ .ID }}].tags" cols="20" rows="2" readonly>{{ join .Tags " " }}</textarea>
@@ -521,9 +1069,7 @@ var (
               {{ .Source }}
             </td>
             <td>
-              {{ if eq .Rating "s" }} Safe
-              {{ else if eq .Rating "q" }} Questionable
-              {{ else if eq .Rating "e" }} Explicit
+              {{ with (ratings).Name .Rating }} {{ . }}
               {{ else }} Unknown
               {{ end }}
             </td>
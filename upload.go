@@ -9,8 +9,11 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
+
+	"github.com/kusubooru/tagaa/bulk"
 )
 
 const (
@@ -29,54 +32,65 @@ func uploadHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func serveUpload(w http.ResponseWriter, r *http.Request) {
-	m, err := loadFromCSVFile(globalModel.WorkingDir, globalModel.CSVFilename)
-	if err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not load from CSV File: %v", err)
-	} else {
-		globalModel = m
-	}
-
-	render(w, uploadTmpl, globalModel)
+	render(w, uploadTmpl, reloadModel())
 }
 
 func handleUpload(w http.ResponseWriter, r *http.Request) {
 
-	m, err := loadFromCSVFile(globalModel.WorkingDir, globalModel.CSVFilename)
-	if err != nil {
-		globalModel.Err = fmt.Errorf("Error: could not load from CSV File: %v", err)
-	} else {
-		globalModel = m
+	m := reloadModel()
+
+	if err := checkRatings(m.Images); err != nil {
+		m.Err = fmt.Errorf("Error: %v", err)
+		render(w, uploadTmpl, m)
+		return
 	}
 
-	uploadFiles, err := readUploadFiles(globalModel)
+	uploadFiles, err := readUploadFiles(m)
 	if err != nil {
 		//http.Error(w, fmt.Sprintf("Failed to read upload files: %v", err), http.StatusInternalServerError)
-		globalModel.Err = fmt.Errorf("Failed to read upload files: %v", err)
-		render(w, uploadTmpl, globalModel)
+		m.Err = fmt.Errorf("Failed to read upload files: %v", err)
+		render(w, uploadTmpl, m)
 		return
 	}
 
-	workingDirBase := filepath.Base(globalModel.WorkingDir)
-	zipFilename := filepath.Join(globalModel.WorkingDir, workingDirBase+".zip")
+	workingDirBase := filepath.Base(m.WorkingDir)
+	zipFilename := filepath.Join(m.WorkingDir, workingDirBase+".zip")
 	if err := zipFiles(uploadFiles, zipFilename, workingDirBase); err != nil {
 		//http.Error(w, fmt.Sprintf("Failed to zip files: %v", err), http.StatusInternalServerError)
-		globalModel.Err = fmt.Errorf("Failed to zip files: %v", err)
-		render(w, uploadTmpl, globalModel)
+		m.Err = fmt.Errorf("Failed to zip files: %v", err)
+		render(w, uploadTmpl, m)
 		return
 	}
 
 	username := r.PostFormValue("username")
 	password := r.PostFormValue("password")
 
-	remain, err := postFile(zipFilename, *uploadURL, uploadFormFileName, username, password)
+	remain, err := postFile(zipFilename, projectConfig().UploadURL, uploadFormFileName, username, password)
 	if err != nil {
 		//http.Error(w, fmt.Sprintf("Failed to upload zip file: %v", err), http.StatusInternalServerError)
-		globalModel.Err = fmt.Errorf("Failed to upload zip file: %v", err)
-		render(w, uploadTmpl, globalModel)
+		m.Err = fmt.Errorf("Failed to upload zip file: %v", err)
+		render(w, uploadTmpl, m)
 		return
 	}
-	globalModel.Success = fmt.Sprintf("Upload was successful! (%v MB remain)", remain/1024/1024)
-	render(w, uploadTmpl, globalModel)
+	m.Success = fmt.Sprintf("Upload was successful! (%v MB remain)", remain/1024/1024)
+	render(w, uploadTmpl, m)
+}
+
+// checkRatings reports the first uploadable image that is not rated or whose
+// rating is not one of the ratings of the board.
+func checkRatings(images []bulk.Image) error {
+	for _, img := range images {
+		if t, _ := bulk.MediaTypes.Lookup(img.Name); !t.Uploadable {
+			continue
+		}
+		if img.Rating == "" {
+			return fmt.Errorf("%v has no rating, it must be one of %q", img.Name, bulk.Ratings.Values())
+		}
+		if !bulk.Ratings.Valid(img.Rating) {
+			return fmt.Errorf("%v has rating %q which is not one of %q", img.Name, img.Rating, bulk.Ratings.Values())
+		}
+	}
+	return nil
 }
 
 type uploadFile struct {
@@ -88,17 +102,31 @@ type uploadFile struct {
 func readUploadFiles(model *model) ([]*uploadFile, error) {
 	var uploadFiles []*uploadFile
 	csvFile := filepath.Join(model.WorkingDir, model.CSVFilename)
-	csvBody, err := ioutil.ReadFile(csvFile)
-	if err != nil {
-		return nil, fmt.Errorf("read csv file: %v", err)
-	}
 	info, err := os.Stat(csvFile)
 	if err != nil {
 		return nil, fmt.Errorf("stat csv file: %v", err)
 	}
-	uploadFiles = append(uploadFiles, &uploadFile{Name: model.CSVFilename, Body: csvBody, Info: info})
 
-	for _, img := range model.Images {
+	// Identical files are only uploaded once and the CSV file is written
+	// for the uploaded ones so that it does not list files missing from the
+	// zip.
+	var images []bulk.Image
+	for _, img := range bulk.Unique(model.Images) {
+		if t, _ := bulk.MediaTypes.Lookup(img.Name); t.Uploadable {
+			images = append(images, img)
+		}
+	}
+	// The Bulk Add CSV extension reads every row as an image so the header
+	// row is left out.
+	var csvBody bytes.Buffer
+	cw := model.csvWriter(&csvBody)
+	cw.Header = nil
+	if err := cw.WriteAll(images); err != nil {
+		return nil, fmt.Errorf("write csv file: %v", err)
+	}
+	uploadFiles = append(uploadFiles, &uploadFile{Name: model.CSVFilename, Body: csvBody.Bytes(), Info: info})
+
+	for _, img := range images {
 		imgFile := filepath.Join(model.WorkingDir, img.Name)
 		imgBody, err := ioutil.ReadFile(imgFile)
 		if err != nil {
@@ -131,8 +159,9 @@ func zipFiles(uploadFiles []*uploadFile, zipFilename, dirName string) error {
 		if ierr != nil {
 			return fmt.Errorf("file info header: %v", ierr)
 		}
-		// Putting the files under a directory.
-		header.Name = filepath.Join(dirName, header.Name)
+		// Putting the files under a directory. File names might be relative
+		// paths when working recursively and zip expects '/' as separator.
+		header.Name = path.Join(dirName, filepath.ToSlash(file.Name))
 
 		hw, herr := zw.CreateHeader(header)
 		if herr != nil {
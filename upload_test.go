@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kusubooru/tagaa/bulk"
+)
+
+func TestCheckRatings(t *testing.T) {
+	tests := []struct {
+		images []bulk.Image
+		ok     bool
+	}{
+		{[]bulk.Image{{Name: "a.png", Rating: "s"}, {Name: "b.jpg", Rating: "e"}}, true},
+		{[]bulk.Image{{Name: "a.png", Rating: "s"}, {Name: "b.jpg"}}, false},
+		{[]bulk.Image{{Name: "a.png", Rating: "x"}}, false},
+		// Files that are not uploaded are not checked.
+		{[]bulk.Image{{Name: "a.png", Rating: "s"}, {Name: "notes.txt"}}, true},
+	}
+	for _, tt := range tests {
+		err := checkRatings(tt.images)
+		if tt.ok && err != nil {
+			t.Errorf("checkRatings(%+v) returned err: %v", tt.images, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("checkRatings(%+v) expected to return err", tt.images)
+		}
+	}
+}